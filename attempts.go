@@ -0,0 +1,157 @@
+package retryer
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jstemmer/go-junit-report/v2/gtr"
+)
+
+// attempt is the result of a test identity in one round. With -count>1 an
+// identity runs several times per round, and the attempt fails if any of
+// the runs fails, unless it was voted on with the majority pass policy and
+// at least minPasses runs passed. votes are the results of the runs in
+// order, duration is their total duration.
+type attempt struct {
+	round     int
+	passed    int
+	failed    int
+	votes     []bool
+	voted     bool
+	minPasses int
+	duration  time.Duration
+}
+
+func (a attempt) isFailed() bool {
+	if a.minPasses > 0 {
+		return a.passed < a.minPasses
+	}
+	return a.failed > 0
+}
+
+// testVerdict is the final result of a test identity.
+type testVerdict string
+
+const (
+	verdictPassed testVerdict = "passed"
+	verdictFlaky  testVerdict = "flaky"
+	verdictFailed testVerdict = "failed"
+)
+
+// testRecord keeps attempts of a test identity in the order of rounds.
+type testRecord struct {
+	attempts []attempt
+}
+
+// verdict returns failed if the last attempt failed, flaky if an earlier
+// attempt failed and passed otherwise.
+func (rec *testRecord) verdict() testVerdict {
+	if len(rec.attempts) == 0 {
+		return verdictPassed
+	}
+	if rec.attempts[len(rec.attempts)-1].isFailed() {
+		return verdictFailed
+	}
+	for _, a := range rec.attempts {
+		if a.isFailed() {
+			return verdictFlaky
+		}
+	}
+	return verdictPassed
+}
+
+// testRepetitions describes how go test repeats every test of a package in
+// one run: for every -cpu value in order, -count times.
+type testRepetitions struct {
+	cpus  []string
+	count int
+}
+
+func testRepetitionsFromArgs(args testArgs) testRepetitions {
+	repetitions := testRepetitions{count: 1}
+	if count, ok := args.flagValue("count"); ok {
+		if n, err := strconv.Atoi(count); err == nil && n > 0 {
+			repetitions.count = n
+		}
+	}
+	if cpu, ok := args.flagValue("cpu"); ok {
+		for _, value := range strings.Split(cpu, ",") {
+			if value = strings.TrimSpace(value); value != "" {
+				repetitions.cpus = append(repetitions.cpus, value)
+			}
+		}
+	}
+	return repetitions
+}
+
+// cpu returns the -cpu value of the n-th run of a test in a package, or an
+// empty string if tests don't run with several -cpu values.
+func (rep testRepetitions) cpu(n int) string {
+	if len(rep.cpus) < 2 {
+		return ""
+	}
+	i := n / rep.count
+	if i >= len(rep.cpus) {
+		i = len(rep.cpus) - 1
+	}
+	return rep.cpus[i]
+}
+
+// attemptsFromPackage returns attempts of the root tests of the package, in
+// the order test identities first appear in the package.
+func (rep testRepetitions) attemptsFromPackage(pkg gtr.Package, round int) ([]testID, map[testID]*attempt) {
+	var ids []testID
+	attempts := make(map[testID]*attempt)
+	runs := make(map[string]int)
+	for _, test := range filter(pkg.Tests, isRootTest) {
+		id := testID{pkg: pkg.Name, name: test.Name, cpu: rep.cpu(runs[test.Name])}
+		runs[test.Name]++
+
+		a, ok := attempts[id]
+		if !ok {
+			a = &attempt{round: round}
+			attempts[id] = a
+			ids = append(ids, id)
+		}
+		a.duration += test.Duration
+		a.votes = append(a.votes, isPassedTest(test))
+		switch {
+		case isFailedTest(test):
+			a.failed++
+		case isPassedTest(test):
+			a.passed++
+		}
+	}
+	return ids, attempts
+}
+
+// recordAttempt adds the attempt to the record of the test identity.
+func (r *Retryer) recordAttempt(id testID, a attempt) {
+	rec, ok := r.testRecords[id]
+	if !ok {
+		rec = &testRecord{}
+		r.testRecords[id] = rec
+	}
+	rec.attempts = append(rec.attempts, a)
+}
+
+// testsWithVerdict returns test identities with the given verdict.
+func (r *Retryer) testsWithVerdict(verdict testVerdict) []testID {
+	tests := make(map[testID]struct{})
+	for id, rec := range r.testRecords {
+		if rec.verdict() == verdict {
+			tests[id] = struct{}{}
+		}
+	}
+	return sortedTestIDs(tests)
+}
+
+func (r *Retryer) logVerdicts() {
+	if flaky := r.testsWithVerdict(verdictFlaky); len(flaky) > 0 {
+		r.logger.Info("Flaky tests", "tests", flaky)
+	}
+	if failed := r.testsWithVerdict(verdictFailed); len(failed) > 0 {
+		r.logger.Info("Failed tests after retries", "tests", failed)
+	}
+}
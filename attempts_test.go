@@ -0,0 +1,32 @@
+package retryer
+
+import (
+	"testing"
+
+	"github.com/jstemmer/go-junit-report/v2/gtr"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAttemptsFromPackageWithCountAndCPU(t *testing.T) {
+	pkg := gtr.Package{Name: "pkg"}
+	for _, result := range []gtr.Result{gtr.Pass, gtr.Fail, gtr.Pass, gtr.Pass} {
+		pkg.Tests = append(pkg.Tests, gtr.Test{Name: "TestA", Result: result})
+	}
+	rep := testRepetitions{cpus: []string{"1", "4"}, count: 2}
+
+	ids, attempts := rep.attemptsFromPackage(pkg, 1)
+
+	cpu1 := testID{pkg: "pkg", name: "TestA", cpu: "1"}
+	cpu4 := testID{pkg: "pkg", name: "TestA", cpu: "4"}
+	assert.Equal(t, []testID{cpu1, cpu4}, ids)
+	assert.Equal(t, attempt{round: 1, passed: 1, failed: 1, votes: []bool{true, false}}, *attempts[cpu1])
+	assert.Equal(t, attempt{round: 1, passed: 2, votes: []bool{true, true}}, *attempts[cpu4])
+}
+
+func TestTestRecordVerdict(t *testing.T) {
+	assert.Equal(t, verdictPassed, (&testRecord{attempts: []attempt{{passed: 2}}}).verdict())
+	assert.Equal(t, verdictFlaky, (&testRecord{attempts: []attempt{{failed: 1, passed: 1}, {passed: 2}}}).verdict())
+	assert.Equal(t, verdictFailed, (&testRecord{attempts: []attempt{{passed: 1}, {failed: 1}}}).verdict())
+	assert.Equal(t, verdictFlaky, (&testRecord{attempts: []attempt{{failed: 1}, {passed: 2, failed: 1, minPasses: 2}}}).verdict())
+	assert.Equal(t, verdictFailed, (&testRecord{attempts: []attempt{{failed: 1}, {passed: 1, failed: 2, minPasses: 2}}}).verdict())
+}
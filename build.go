@@ -0,0 +1,63 @@
+package retryer
+
+import (
+	"strings"
+
+	"github.com/jstemmer/go-junit-report/v2/gtr"
+)
+
+const (
+	onBuildErrorContinue = "continue"
+	onBuildErrorAbort    = "abort"
+)
+
+// buildFailure is a package whose test binary couldn't be built, either
+// because of compilation errors or because go vet, which go test runs
+// before the tests, reported problems.
+type buildFailure struct {
+	pkg    string
+	vet    bool
+	output []string
+}
+
+// buildFailuresFromReport returns build failures of the report packages in
+// the order they appear. go test prints vet output under a "# [pkg]" header,
+// which the parser reports as a build error of a separate "[pkg]" package,
+// so such errors are merged into the failure of the package they belong to.
+func buildFailuresFromReport(report gtr.Report) []buildFailure {
+	var packages []string
+	failures := make(map[string]*buildFailure)
+	compileErrors := make(map[string]bool)
+	vetErrors := make(map[string]bool)
+
+	for _, pkg := range report.Packages {
+		if pkg.BuildError.Name == "" {
+			continue
+		}
+
+		name := pkg.BuildError.Name
+		vet := strings.HasPrefix(name, "[") && strings.HasSuffix(name, "]")
+		name = strings.TrimSuffix(strings.Trim(name, "[]"), "_test")
+
+		failure, ok := failures[name]
+		if !ok {
+			failure = &buildFailure{pkg: name}
+			failures[name] = failure
+			packages = append(packages, name)
+		}
+		failure.output = append(failure.output, pkg.BuildError.Output...)
+		if vet {
+			vetErrors[name] = true
+		} else if len(pkg.BuildError.Output) > 0 {
+			compileErrors[name] = true
+		}
+	}
+
+	buildFailures := make([]buildFailure, 0, len(packages))
+	for _, pkg := range packages {
+		failure := failures[pkg]
+		failure.vet = vetErrors[pkg] && !compileErrors[pkg]
+		buildFailures = append(buildFailures, *failure)
+	}
+	return buildFailures
+}
@@ -0,0 +1,113 @@
+package retryer
+
+import (
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/jstemmer/go-junit-report/v2/gtr"
+)
+
+var (
+	regexFailureMessage = regexp.MustCompile(`^\s*([^\s:]+\.go:\d+): (.*)$`)
+	regexFrameLocation  = regexp.MustCompile(`^\t(\S+\.go:\d+)`)
+	regexStdlibFrame    = regexp.MustCompile(`/src/(runtime|testing)/`)
+)
+
+// failureCluster is a group of failed tests sharing the cause of failure,
+// e.g. a broken helper or fixture.
+type failureCluster struct {
+	cause string
+	tests []testID
+}
+
+// failureCause returns the normalized root cause of a failure in the test
+// output: for a panic, its message and the first stack frame outside of the
+// runtime and testing packages, otherwise the first failure message with
+// its location. Tests failing in a shared helper get the same cause, tests
+// failing in their own code don't. It returns an empty string if the output
+// has neither.
+func failureCause(output []string) string {
+	for i, line := range output {
+		if !regexPanic.MatchString(line) {
+			continue
+		}
+		cause := normalizeFailureLine(line)
+		for _, frame := range output[i+1:] {
+			matches := regexFrameLocation.FindStringSubmatch(frame)
+			if matches != nil && !regexStdlibFrame.MatchString(matches[1]) {
+				return cause + " at " + filepath.Base(matches[1])
+			}
+		}
+		return cause
+	}
+	for _, line := range output {
+		if matches := regexFailureMessage.FindStringSubmatch(line); matches != nil {
+			return filepath.Base(matches[1]) + ": " + normalizeFailureLine(matches[2])
+		}
+	}
+	return ""
+}
+
+// normalizeFailureLine replaces parts of a failure line that differ between
+// failures with the same cause, as failure fingerprints do.
+func normalizeFailureLine(line string) string {
+	for _, n := range fingerprintNormalizers {
+		line = n.regex.ReplaceAllString(line, n.replacement)
+	}
+	return strings.TrimSpace(line)
+}
+
+// failureCausesFromReport returns failure causes of failed root tests of the
+// report. The go test runner prints panics of a test in the package output,
+// which is used for tests that panicked, timed out or crashed.
+func failureCausesFromReport(report gtr.Report, kinds map[testID]failureKind) map[testID]string {
+	causes := make(map[testID]string)
+	for _, pkg := range report.Packages {
+		for _, test := range filter(pkg.Tests, isRootTest, isFailedTest) {
+			id := testID{pkg: pkg.Name, name: test.Name}
+			output := testOutputWithSubtests(pkg, test.Name)
+			if kind := kinds[id]; kind != failureKindFail && kind != failureKindRace && !anyLineMatches(output, regexPanic) {
+				output = append(output, pkg.Output...)
+			}
+			if cause := failureCause(output); cause != "" {
+				causes[id] = cause
+			}
+		}
+	}
+	return causes
+}
+
+// failureClusters groups tests that failed after retries by the cause of
+// their last failure. Only causes shared by several tests form clusters,
+// the largest first.
+func (r *Retryer) failureClusters() []failureCluster {
+	byCause := make(map[string][]testID)
+	for _, test := range r.testsWithVerdict(verdictFailed) {
+		if cause, ok := r.failureCauses[test]; ok {
+			byCause[cause] = append(byCause[cause], test)
+		}
+	}
+
+	var clusters []failureCluster
+	for cause, tests := range byCause {
+		if len(tests) > 1 {
+			clusters = append(clusters, failureCluster{cause: cause, tests: tests})
+		}
+	}
+	sort.Slice(clusters, func(i, j int) bool {
+		if len(clusters[i].tests) != len(clusters[j].tests) {
+			return len(clusters[i].tests) > len(clusters[j].tests)
+		}
+		return clusters[i].cause < clusters[j].cause
+	})
+	return clusters
+}
+
+func (r *Retryer) logFailureClusters(clusters []failureCluster) {
+	for _, cluster := range clusters {
+		r.logger.Info("Tests failed with the same cause",
+			"cause", cluster.cause, "count", len(cluster.tests), "tests", cluster.tests)
+	}
+}
@@ -0,0 +1,67 @@
+package retryer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFailureCause(t *testing.T) {
+	testCases := []struct {
+		name     string
+		output   []string
+		expected string
+	}{
+		{
+			name:     "failure message",
+			output:   []string{"    fixture_test.go:12: open db at 2024-03-01T10:15:30Z: connection refused"},
+			expected: "fixture_test.go:12: open db at <time>: connection refused",
+		},
+		{
+			name: "panic",
+			output: []string{
+				"panic: runtime error: invalid memory address or nil pointer dereference [recovered]",
+				"goroutine 7 [running]:",
+				"testing.tRunner.func1.2({0x5c4f20, 0x6f0a10})",
+				"\t/usr/local/go/src/testing/testing.go:1632 +0x230",
+				"example.com/m/pkg.setupFixture(...)",
+				"\t/home/user/m/pkg/fixture_test.go:20 +0x1d",
+			},
+			expected: "panic: runtime error: invalid memory address or nil pointer dereference [recovered] at fixture_test.go:20",
+		},
+		{
+			name:     "no failure message",
+			output:   []string{"some output"},
+			expected: "",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, failureCause(tc.output))
+		})
+	}
+}
+
+func TestFailureClusters(t *testing.T) {
+	failed := &testRecord{attempts: []attempt{{failed: 1}}}
+	r := &Retryer{
+		testRecords: map[testID]*testRecord{
+			{pkg: "pkg", name: "TestA"}:   failed,
+			{pkg: "pkg", name: "TestB"}:   failed,
+			{pkg: "other", name: "TestC"}: failed,
+			{pkg: "pkg", name: "TestD"}:   failed,
+		},
+		failureCauses: map[testID]string{
+			{pkg: "pkg", name: "TestA"}:   "fixture_test.go:12: connection refused",
+			{pkg: "pkg", name: "TestB"}:   "fixture_test.go:12: connection refused",
+			{pkg: "other", name: "TestC"}: "fixture_test.go:12: connection refused",
+			{pkg: "pkg", name: "TestD"}:   "d_test.go:5: wrong answer",
+		},
+	}
+
+	assert.Equal(t, []failureCluster{{
+		cause: "fixture_test.go:12: connection refused",
+		tests: []testID{{pkg: "other", name: "TestC"}, {pkg: "pkg", name: "TestA"}, {pkg: "pkg", name: "TestB"}},
+	}}, r.failureClusters())
+}
@@ -10,6 +10,14 @@ import (
 func main() {
 	errorLogger := log.New(os.Stderr, "", 0)
 
+	if len(os.Args) > 1 && os.Args[1] == "config" {
+		if err := rt.PrintEffectiveConfig(os.Args[2:], os.Stdout); err != nil {
+			errorLogger.Println(err)
+			os.Exit(2)
+		}
+		os.Exit(0)
+	}
+
 	cfg, err := rt.NewConfigFromArgs(os.Args)
 	if err != nil {
 		errorLogger.Println(err)
@@ -27,6 +35,10 @@ func main() {
 		os.Exit(0)
 	case rt.TestError:
 		os.Exit(err.TestExitCode())
+	case rt.InvalidParameterError:
+		os.Exit(2)
+	case rt.InternalError:
+		os.Exit(err.ExitCode())
 	default:
 		os.Exit(1)
 	}
@@ -1,36 +1,224 @@
 package retryer
 
-import "flag"
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const printEffectiveFlag = "print-effective"
 
 type Config struct {
-	testOutputTypeJSON bool
-	maxRetriesPerTest  int
-	maxTotalRetries    int
-	testCommandName    string
-	testArgs           string
-	verbose            bool
-	shellPath          string
+	testOutputTypeJSON    bool
+	maxRetriesPerTest     int
+	maxTotalRetries       int
+	failureKindRetries    failureKindRetries
+	overrides             overrideRules
+	directives            bool
+	maxFailures           int
+	maxFailurePercent     float64
+	failureThresholdScope string
+	maxIdenticalFailures  int
+	retryPriority         string
+	historyFile           string
+	retryTimeBudget       time.Duration
+	maxRetryTestDuration  time.Duration
+	passThreshold         int
+	passPolicy            string
+	retryFailedPackages   bool
+	runMissingTests       bool
+	onBuildError          string
+	exitCodes             exitCodes
+	strict                bool
+	outputTailLines       int
+	fullOutputDir         string
+	testCommandName       string
+	testArgs              string
+	initialOutput         string
+	dryRun                bool
+	formatMismatch        string
+	addFormatFlags        bool
+	prebuild              bool
+	console               string
+	color                 string
+	rawJSONFile           string
+	logLevel              slog.Level
+	logFormat             string
+	logFile               string
+	shellPath             string
+	configPath            string
+	profile               string
+	optionSources         []optionSource
 }
 
 func NewConfigFromArgs(args []string) (Config, error) {
-	cfg := Config{}
+	return newConfig(args[1:], nil)
+}
+
+// PrintEffectiveConfig implements "go-test-retryer config --print-effective
+// [flags]": it prints the effective value of every option with its source
+// and environment variable.
+func PrintEffectiveConfig(args []string, w io.Writer) error {
+	printEffective := false
+	cfg, err := newConfig(args, func() {
+		flag.BoolVar(&printEffective, printEffectiveFlag, false, "print effective options with their sources and environment variables")
+	})
+	if err != nil {
+		return err
+	}
+	if !printEffective {
+		return InvalidParameterError{"Config command requires --" + printEffectiveFlag}
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "OPTION\tVALUE\tSOURCE\tENVIRONMENT VARIABLE")
+	for _, option := range cfg.optionSources {
+		if option.name == printEffectiveFlag {
+			continue
+		}
+		fmt.Fprintf(tw, "%v\t%q\t%v\t%v\n", option.name, option.value, option.source, envName(option.name))
+	}
+	return tw.Flush()
+}
+
+// newConfig parses flags of the arguments; defineFlags, if set, defines
+// flags of a subcommand.
+func newConfig(args []string, defineFlags func()) (Config, error) {
+	cfg := Config{
+		failureKindRetries: make(failureKindRetries),
+		exitCodes:          defaultExitCodes(),
+	}
 
 	flag.BoolVar(&cfg.testOutputTypeJSON, "json", false, "parse go test output as json")
 	flag.IntVar(&cfg.maxRetriesPerTest, "retries-per-test", 0, "maximum retries per test")
 	flag.IntVar(&cfg.maxTotalRetries, "total-retries", 0, "maximum retries for all tests")
-	flag.BoolVar(&cfg.verbose, "verbose", false, "verbose mode")
+	flag.Var(cfg.failureKindRetries, "failure-kind-retries", "maximum retries per test by failure kind, e.g. race=0,timeout=1")
+	flag.Var(&cfg.overrides, "overrides", "YAML rule or list of rules overriding retries of tests, e.g. {package: ./integration/..., test: ^TestDB, retries: 3, timeout: 5m, retry: true}")
+	flag.BoolVar(&cfg.directives, "directives", false, "read //retryer: retry directives from _test.go files of packages with failed tests")
+	flag.IntVar(&cfg.maxFailures, "max-failures", 0, "don't retry if more tests fail in the first run, 0 means unlimited")
+	flag.Float64Var(&cfg.maxFailurePercent, "max-failure-percent", 0, "don't retry if a larger percentage of executed tests fails in the first run, 0 means unlimited")
+	flag.StringVar(&cfg.failureThresholdScope, "failure-threshold-scope", thresholdScopeRun, "apply failure thresholds to the whole run, skipping all retries, or to every package, skipping its retries: run or package")
+	flag.IntVar(&cfg.maxIdenticalFailures, "max-identical-failures", 0, "stop retrying a test once this many consecutive attempts fail with identical output, 0 means never")
+	flag.StringVar(&cfg.retryPriority, "retry-priority", priorityReport, "order failed tests get the total retries budget in: report, cheapest, flakiest (by -history-file) or fewest-attempts")
+	flag.StringVar(&cfg.historyFile, "history-file", "", "YAML file with flakiness of tests in earlier runs by test name, for -retry-priority=flakiest")
+	flag.DurationVar(&cfg.retryTimeBudget, "retry-time-budget", 0, "maximum sum of estimated durations of all retries, estimated by previous attempts, 0 means unlimited")
+	flag.DurationVar(&cfg.maxRetryTestDuration, "max-retry-test-duration", 0, "don't retry tests whose previous attempt took longer, 0 means unlimited")
+	flag.IntVar(&cfg.passThreshold, "pass-threshold", 1, "passes a retried test needs to be accepted, every retry runs it as many times as needed to vote")
+	flag.StringVar(&cfg.passPolicy, "pass-policy", passPolicyConsecutive, "how retry runs vote: consecutive (all runs pass) or majority (threshold passes out of 2*threshold-1 runs)")
+	flag.BoolVar(&cfg.retryFailedPackages, "retry-failed-packages", false, "retry whole packages that failed without failed tests")
+	flag.BoolVar(&cfg.runMissingTests, "run-missing-tests", false, "find tests that didn't run because of -failfast or a crash and run them")
+	flag.StringVar(&cfg.onBuildError, "on-build-error", onBuildErrorContinue, "what to do when a package fails to build: continue or abort")
+	flag.Var(cfg.exitCodes, "exit-codes", "exit codes by outcome, e.g. flaky=0,failed=last,build-failed=2")
+	flag.BoolVar(&cfg.strict, "strict", false, "fail if any test passed only after retries")
+	flag.IntVar(&cfg.outputTailLines, "output-tail-lines", 0, "maximum output lines kept per test and package for failure analysis, 0 means unlimited")
+	flag.StringVar(&cfg.fullOutputDir, "full-output-dir", "", "directory to write full output of every test command to")
+	flag.StringVar(&cfg.formatMismatch, "format-mismatch", formatMismatchWarn, "what to do when test output format doesn't match -json: warn or error")
+	flag.BoolVar(&cfg.addFormatFlags, "add-format-flags", false, "add -json (with -json) or -v (without it) to test arguments if missing")
+	flag.BoolVar(&cfg.prebuild, "prebuild", false, "build test binaries of retried packages once and run retries with them instead of go test")
+	flag.StringVar(&cfg.console, "console", consoleRaw, "console output: raw test output or pretty, rendered from go test -json")
+	flag.StringVar(&cfg.color, "color", colorAuto, "color pretty console output: auto, always or never")
+	flag.StringVar(&cfg.rawJSONFile, "raw-json-file", "", "file to save go test -json output of all test commands to")
+	flag.TextVar(&cfg.logLevel, "log-level", slog.LevelWarn, "minimum level of retryer logs: debug, info, warn or error")
+	flag.StringVar(&cfg.logFormat, "log-format", logFormatText, "format of retryer logs: text or json")
+	flag.StringVar(&cfg.logFile, "log-file", "", "file to write retryer logs to instead of stderr")
 	flag.StringVar(&cfg.testCommandName, "test-command-name", "go test", `test command name`)
 	flag.StringVar(&cfg.testArgs, "test-args", "", "test arguments")
+	flag.StringVar(&cfg.initialOutput, "initial-output", "", `file with output of an initial test run to retry failures of instead of running tests, "-" for stdin`)
+	flag.BoolVar(&cfg.dryRun, "dry-run", false, "print the retry plan for failures of -initial-output and exit without running tests")
 	flag.StringVar(&cfg.shellPath, "shell", "/bin/bash", "path to shell")
-	flag.Parse()
+	flag.StringVar(&cfg.configPath, "config", "", "path to config file, "+configFileName+" in the working directory or its parents by default")
+	flag.StringVar(&cfg.profile, "profile", "", "profile of the config file to use")
+	if defineFlags != nil {
+		defineFlags()
+	}
+	flag.CommandLine.Parse(args)
 
-	if cfg.maxTotalRetries < 0 || cfg.maxRetriesPerTest < 0 {
-		return Config{}, InvalidParameterError{"Retries amount should be non-negative"}
+	var err error
+	if cfg.optionSources, err = applyConfigLayers(&cfg.configPath, &cfg.profile); err != nil {
+		return Config{}, err
+	}
+
+	if cfg.maxTotalRetries < 0 {
+		return Config{}, cfg.invalidOption("Retries amount should be non-negative", "total-retries")
+	}
+	if cfg.maxRetriesPerTest < 0 {
+		return Config{}, cfg.invalidOption("Retries amount should be non-negative", "retries-per-test")
+	}
+	if cfg.onBuildError != onBuildErrorContinue && cfg.onBuildError != onBuildErrorAbort {
+		return Config{}, cfg.invalidOption("On build error should be one of: continue, abort", "on-build-error")
+	}
+	if cfg.formatMismatch != formatMismatchWarn && cfg.formatMismatch != formatMismatchError {
+		return Config{}, cfg.invalidOption("Format mismatch should be one of: warn, error", "format-mismatch")
+	}
+	if cfg.console != consoleRaw && cfg.console != consolePretty {
+		return Config{}, cfg.invalidOption("Console should be one of: raw, pretty", "console")
+	}
+	if cfg.color != colorAuto && cfg.color != colorAlways && cfg.color != colorNever {
+		return Config{}, cfg.invalidOption("Color should be one of: auto, always, never", "color")
+	}
+	if cfg.console == consolePretty {
+		// Pretty output is rendered from test events.
+		cfg.testOutputTypeJSON = true
+	}
+	if cfg.logFormat != logFormatText && cfg.logFormat != logFormatJSON {
+		return Config{}, cfg.invalidOption("Log format should be one of: text, json", "log-format")
+	}
+	if cfg.maxFailures < 0 {
+		return Config{}, cfg.invalidOption("Failure thresholds should be non-negative", "max-failures")
+	}
+	if cfg.maxFailurePercent < 0 || cfg.maxFailurePercent > 100 {
+		return Config{}, cfg.invalidOption("Failure percentage should be from 0 to 100", "max-failure-percent")
+	}
+	if cfg.failureThresholdScope != thresholdScopeRun && cfg.failureThresholdScope != thresholdScopePackage {
+		return Config{}, cfg.invalidOption("Failure threshold scope should be one of: run, package", "failure-threshold-scope")
+	}
+	if cfg.maxIdenticalFailures < 0 || cfg.maxIdenticalFailures == 1 {
+		return Config{}, cfg.invalidOption("Max identical failures should be 0 or at least 2", "max-identical-failures")
+	}
+	if !slices.Contains(priorities, cfg.retryPriority) {
+		return Config{}, cfg.invalidOption("Retry priority should be one of: "+strings.Join(priorities, ", "), "retry-priority")
+	}
+	if cfg.retryPriority == priorityFlakiest && cfg.historyFile == "" {
+		return Config{}, cfg.invalidOption("Flakiest retry priority requires history file", "retry-priority")
+	}
+	if cfg.retryTimeBudget < 0 {
+		return Config{}, cfg.invalidOption("Retry durations should be non-negative", "retry-time-budget")
+	}
+	if cfg.maxRetryTestDuration < 0 {
+		return Config{}, cfg.invalidOption("Retry durations should be non-negative", "max-retry-test-duration")
+	}
+	if cfg.passThreshold < 1 {
+		return Config{}, cfg.invalidOption("Pass threshold should be positive", "pass-threshold")
+	}
+	if cfg.passPolicy != passPolicyConsecutive && cfg.passPolicy != passPolicyMajority {
+		return Config{}, cfg.invalidOption("Pass policy should be one of: consecutive, majority", "pass-policy")
+	}
+	if cfg.outputTailLines < 0 {
+		return Config{}, cfg.invalidOption("Output tail lines should be non-negative", "output-tail-lines")
+	}
+	for _, retries := range cfg.failureKindRetries {
+		if retries < 0 {
+			return Config{}, cfg.invalidOption("Retries amount should be non-negative", "failure-kind-retries")
+		}
+	}
+	if cfg.dryRun && cfg.initialOutput == "" {
+		return Config{}, cfg.invalidOption("Dry run requires initial output", "dry-run")
 	}
 
 	return cfg, nil
 }
 
+// areRetriesAllowed reports whether any option allows retries, otherwise
+// tests are run once.
+func (cfg *Config) areRetriesAllowed() bool {
+	return cfg.maxRetriesPerTest > 0 || len(cfg.overrides) > 0 || cfg.directives
+}
+
 func (cfg *Config) isTotalRetriesLimitEnabled() bool {
 	return cfg.maxTotalRetries != 0
 }
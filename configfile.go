@@ -0,0 +1,286 @@
+package retryer
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// configFileName is the name of the project configuration file, looked up
+// from the working directory upward.
+const configFileName = ".go-test-retryer.yaml"
+
+const (
+	sourceDefault     = "default"
+	sourceFlag        = "flag"
+	sourceEnvironment = "environment"
+)
+
+// envPrefix starts names of environment variables setting options, e.g.
+// GO_TEST_RETRYER_RETRIES_PER_TEST sets -retries-per-test.
+const envPrefix = "GO_TEST_RETRYER_"
+
+// configFile holds options of the configuration file by flag name. Options
+// at the top level apply to every run, options of a profile apply when it's
+// selected with -profile and override the top level ones.
+type configFile struct {
+	path     string
+	options  map[string]any
+	profiles map[string]map[string]any
+}
+
+// optionSource is the effective value of an option and where it came from.
+type optionSource struct {
+	name   string
+	value  string
+	source string
+}
+
+// applyConfigLayers sets flags that weren't set on the command line from
+// environment variables, the configuration file and the selected profile,
+// and returns the effective value and source of every option. Flags take
+// precedence over the environment, which takes precedence over the profile,
+// the top level of the file and defaults. path and profile are flag values
+// choosing the file and the profile, they can be set by the environment.
+func applyConfigLayers(path, profile *string) ([]optionSource, error) {
+	sources := make(map[string]string)
+	flag.Visit(func(f *flag.Flag) {
+		sources[f.Name] = sourceFlag
+	})
+	if err := applyEnvironment(sources, false); err != nil {
+		return nil, err
+	}
+	// Flags and environment aren't overridden by the file.
+	fixed := make(map[string]struct{}, len(sources))
+	for name := range sources {
+		fixed[name] = struct{}{}
+	}
+
+	if *path == "" {
+		var err error
+		if *path, err = findConfigFile(); err != nil {
+			return nil, err
+		}
+	}
+	var file *configFile
+	if *path != "" {
+		var err error
+		if file, err = loadConfigFile(*path); err != nil {
+			return nil, err
+		}
+	}
+
+	// Lower layers go first, so that higher ones overwrite their values.
+	type layer struct {
+		source  string
+		options map[string]any
+	}
+	var layers []layer
+	if file != nil {
+		layers = append(layers, layer{"config file " + file.path, file.options})
+	}
+	if *profile != "" {
+		options, ok := file.profile(*profile)
+		if !ok {
+			return nil, InvalidParameterError{fmt.Sprintf("Profile %q not found in config file", *profile)}
+		}
+		layers = append(layers, layer{fmt.Sprintf("profile %v of config file %v", *profile, file.path), options})
+	}
+
+	for _, layer := range layers {
+		for _, name := range sortedKeys(layer.options) {
+			f := flag.Lookup(name)
+			if f == nil || !isConfigFileOption(name) {
+				return nil, InvalidParameterError{fmt.Sprintf("Unknown option %q in %v", name, layer.source)}
+			}
+			if _, ok := fixed[name]; ok {
+				continue
+			}
+			value := optionValueString(layer.options[name])
+			if _, ok := f.Value.(yamlValue); ok {
+				data, err := yaml.Marshal(layer.options[name])
+				if err != nil {
+					return nil, errors.Wrap(err, "marshal option")
+				}
+				value = string(data)
+			}
+			if err := f.Value.Set(value); err != nil {
+				return nil, InvalidParameterError{fmt.Sprintf("Invalid value %q of option %q in %v: %v", value, name, layer.source, err)}
+			}
+			sources[name] = layer.source
+		}
+	}
+
+	return effectiveOptions(sources), nil
+}
+
+// effectiveOptions returns the value of every option with its source from
+// sources, options missing there have default values.
+func effectiveOptions(sources map[string]string) []optionSource {
+	var effective []optionSource
+	flag.VisitAll(func(f *flag.Flag) {
+		source, ok := sources[f.Name]
+		if !ok {
+			source = sourceDefault
+		}
+		effective = append(effective, optionSource{name: f.Name, value: f.Value.String(), source: source})
+	})
+	return effective
+}
+
+// applyEnvironment sets flags that weren't set on the command line from
+// GO_TEST_RETRYER_* environment variables. Variables that don't match any
+// option are errors, so that typos don't go unnoticed, unless ignoreUnknown
+// is set for commands having only some of the options.
+func applyEnvironment(sources map[string]string, ignoreUnknown bool) error {
+	names := make(map[string]string)
+	flag.VisitAll(func(f *flag.Flag) {
+		names[envName(f.Name)] = f.Name
+	})
+
+	for _, env := range os.Environ() {
+		key, value, _ := strings.Cut(env, "=")
+		if !strings.HasPrefix(key, envPrefix) {
+			continue
+		}
+		name, ok := names[key]
+		if !ok && ignoreUnknown {
+			continue
+		}
+		if !ok {
+			return InvalidParameterError{fmt.Sprintf("Unknown environment variable %v", key)}
+		}
+		if sources[name] == sourceFlag {
+			continue
+		}
+		if err := flag.Lookup(name).Value.Set(value); err != nil {
+			return InvalidParameterError{fmt.Sprintf("Invalid value %q of environment variable %v: %v", value, key, err)}
+		}
+		sources[name] = sourceEnvironment
+	}
+	return nil
+}
+
+// invalidOption returns the error of an invalid option value, naming where
+// the value was set, so that a bad environment variable or config file key
+// is easy to find.
+func (cfg *Config) invalidOption(message, name string) InvalidParameterError {
+	for _, option := range cfg.optionSources {
+		if option.name != name {
+			continue
+		}
+		switch option.source {
+		case sourceDefault:
+		case sourceFlag:
+			message += fmt.Sprintf(" (flag --%v=%v)", name, option.value)
+		case sourceEnvironment:
+			message += fmt.Sprintf(" (environment variable %v=%v)", envName(name), option.value)
+		default:
+			message += fmt.Sprintf(" (option %v=%v in %v)", name, option.value, option.source)
+		}
+	}
+	return InvalidParameterError{message}
+}
+
+// envName returns the name of the environment variable setting the option.
+func envName(option string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(option, "-", "_"))
+}
+
+// findConfigFile returns the path of the configuration file in the working
+// directory or the closest of its parents, or an empty string if there is
+// none.
+func findConfigFile() (string, error) {
+	dir, err := os.Getwd()
+	if err != nil {
+		return "", errors.Wrap(err, "get working directory")
+	}
+	for {
+		path := filepath.Join(dir, configFileName)
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", nil
+		}
+		dir = parent
+	}
+}
+
+func loadConfigFile(path string) (*configFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, InvalidParameterError{fmt.Sprintf("Couldn't read config file: %v", err)}
+	}
+	var options map[string]any
+	if err := yaml.Unmarshal(data, &options); err != nil {
+		return nil, InvalidParameterError{fmt.Sprintf("Couldn't parse config file %v: %v", path, err)}
+	}
+
+	file := &configFile{path: path, options: options, profiles: make(map[string]map[string]any)}
+	if profiles, ok := options["profiles"]; ok {
+		delete(options, "profiles")
+		profilesMap, ok := profiles.(map[string]any)
+		if !ok {
+			return nil, InvalidParameterError{fmt.Sprintf("Profiles in config file %v should be a mapping", path)}
+		}
+		for name, profile := range profilesMap {
+			profileOptions, ok := profile.(map[string]any)
+			if !ok && profile != nil {
+				return nil, InvalidParameterError{fmt.Sprintf("Profile %q in config file %v should be a mapping", name, path)}
+			}
+			file.profiles[name] = profileOptions
+		}
+	}
+	return file, nil
+}
+
+func (f *configFile) profile(name string) (map[string]any, bool) {
+	if f == nil {
+		return nil, false
+	}
+	options, ok := f.profiles[name]
+	return options, ok
+}
+
+// isConfigFileOption reports whether the option can be set in the
+// configuration file; options choosing the file itself can't.
+func isConfigFileOption(name string) bool {
+	return name != "config" && name != "profile"
+}
+
+// yamlValue is implemented by options set from YAML values of the config
+// file as they are, instead of the flag syntax.
+type yamlValue interface {
+	yamlValue()
+}
+
+// optionValueString converts a YAML value to the flag syntax: mappings such
+// as failure-kind-retries become "key=value" lists, sequences become comma
+// separated lists.
+func optionValueString(value any) string {
+	switch value := value.(type) {
+	case nil:
+		return ""
+	case map[string]any:
+		parts := make([]string, 0, len(value))
+		for _, key := range sortedKeys(value) {
+			parts = append(parts, key+"="+optionValueString(value[key]))
+		}
+		return strings.Join(parts, ",")
+	case []any:
+		parts := make([]string, 0, len(value))
+		for _, item := range value {
+			parts = append(parts, optionValueString(item))
+		}
+		return strings.Join(parts, ",")
+	default:
+		return fmt.Sprint(value)
+	}
+}
@@ -0,0 +1,110 @@
+package retryer
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func TestOptionValueString(t *testing.T) {
+	var options map[string]any
+	require.NoError(t, yaml.Unmarshal([]byte(`
+retries-per-test: 2
+json: true
+test-args: -v ./...
+failure-kind-retries:
+  timeout: 1
+  race: 0
+exit-codes: [flaky=10, failed=1]
+`), &options))
+
+	assert.Equal(t, "2", optionValueString(options["retries-per-test"]))
+	assert.Equal(t, "true", optionValueString(options["json"]))
+	assert.Equal(t, "-v ./...", optionValueString(options["test-args"]))
+	assert.Equal(t, "race=0,timeout=1", optionValueString(options["failure-kind-retries"]))
+	assert.Equal(t, "flaky=10,failed=1", optionValueString(options["exit-codes"]))
+}
+
+func TestPrintEffectiveConfig(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), configFileName)
+	require.NoError(t, os.WriteFile(configPath, []byte(`
+total-retries: 1
+retries-per-test: 1
+strict: true
+profiles:
+  nightly:
+    retries-per-test: 2
+    json: true
+`), 0o644))
+
+	stdout := new(bytes.Buffer)
+	command := exec.Command(retryerBinaryPath, "config", "--print-effective",
+		"-config="+configPath, "-profile=nightly", "-total-retries=5")
+	command.Env = append(os.Environ(), "GO_TEST_RETRYER_RETRIES_PER_TEST=3", "GO_TEST_RETRYER_TOTAL_RETRIES=4")
+	command.Stdout = stdout
+	require.NoError(t, command.Run())
+
+	sources := make(map[string][]string)
+	for _, line := range strings.Split(strings.TrimSpace(stdout.String()), "\n")[1:] {
+		fields := regexp.MustCompile(`\s{2,}`).Split(line, -1)
+		require.Len(t, fields, 4)
+		sources[fields[0]] = fields[1:]
+	}
+	assert.Equal(t, []string{`"5"`, "flag", "GO_TEST_RETRYER_TOTAL_RETRIES"}, sources["total-retries"])
+	assert.Equal(t, []string{`"3"`, "environment", "GO_TEST_RETRYER_RETRIES_PER_TEST"}, sources["retries-per-test"])
+	assert.Equal(t, []string{`"true"`, "profile nightly of config file " + configPath, "GO_TEST_RETRYER_JSON"}, sources["json"])
+	assert.Equal(t, []string{`"true"`, "config file " + configPath, "GO_TEST_RETRYER_STRICT"}, sources["strict"])
+	assert.Equal(t, []string{`"/bin/bash"`, "default", "GO_TEST_RETRYER_SHELL"}, sources["shell"])
+}
+
+func TestInvalidEnvironmentVariable(t *testing.T) {
+	stderr := new(bytes.Buffer)
+	command := exec.Command(retryerBinaryPath, "-test-args=-v")
+	command.Env = append(os.Environ(), "GO_TEST_RETRYER_RETRIES_PER_TEST=many")
+	command.Stderr = stderr
+	err := command.Run()
+
+	exitError, ok := err.(*exec.ExitError)
+	require.True(t, ok)
+	assert.Equal(t, 2, exitError.ExitCode())
+	assert.Equal(t, "Invalid value \"many\" of environment variable GO_TEST_RETRYER_RETRIES_PER_TEST: parse error\n", stderr.String())
+}
+
+func TestInvalidOptionFromEnvironment(t *testing.T) {
+	testCases := []struct {
+		env      string
+		expected string
+	}{
+		{
+			env:      "GO_TEST_RETRYER_RETRIES_PER_TEST=-1",
+			expected: "Retries amount should be non-negative (environment variable GO_TEST_RETRYER_RETRIES_PER_TEST=-1)\n",
+		},
+		{
+			env:      "GO_TEST_RETRYER_ON_BUILD_ERROR=foo",
+			expected: "On build error should be one of: continue, abort (environment variable GO_TEST_RETRYER_ON_BUILD_ERROR=foo)\n",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.env, func(t *testing.T) {
+			stderr := new(bytes.Buffer)
+			command := exec.Command(retryerBinaryPath, "-test-args=-v")
+			command.Env = append(os.Environ(), tc.env)
+			command.Stderr = stderr
+			err := command.Run()
+
+			exitError, ok := err.(*exec.ExitError)
+			require.True(t, ok)
+			assert.Equal(t, 2, exitError.ExitCode())
+			assert.Equal(t, tc.expected, stderr.String())
+		})
+	}
+}
@@ -0,0 +1,267 @@
+package retryer
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	consoleRaw    = "raw"
+	consolePretty = "pretty"
+
+	colorAuto   = "auto"
+	colorAlways = "always"
+	colorNever  = "never"
+)
+
+const (
+	ansiReset  = "\x1b[0m"
+	ansiBold   = "\x1b[1m"
+	ansiDim    = "\x1b[2m"
+	ansiRed    = "\x1b[31m"
+	ansiGreen  = "\x1b[32m"
+	ansiYellow = "\x1b[33m"
+)
+
+// retryInfo returns the number of the retry a test runs in and the maximum
+// retries allowed for it. retry is 0 if the test isn't retried.
+type retryInfo func(id testID) (retry, maxRetries int)
+
+// consoleRenderer renders test events as compact console output: output of
+// failed tests only, markers of retried and flaky tests and a summary line
+// per package.
+type consoleRenderer struct {
+	w       io.Writer
+	color   bool
+	retries retryInfo
+
+	packages map[string]*consolePackage
+}
+
+type consolePackage struct {
+	headerPrinted bool
+	passed        int
+	failed        int
+	skipped       int
+	flaky         int
+	output        map[string][]string
+	packageOutput []string
+}
+
+func newConsoleRenderer(w io.Writer, color bool, retries retryInfo) *consoleRenderer {
+	return &consoleRenderer{
+		w:        w,
+		color:    color,
+		retries:  retries,
+		packages: make(map[string]*consolePackage),
+	}
+}
+
+// useColor decides whether to color console output: always, never, or, in
+// auto mode, if w is a terminal and NO_COLOR isn't set.
+func useColor(mode string, w io.Writer) bool {
+	switch mode {
+	case colorAlways:
+		return true
+	case colorNever:
+		return false
+	}
+	if os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+	file, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := file.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+func (c *consoleRenderer) startRound(round int) {
+	if round > 1 {
+		c.printf(ansiDim, "=== Retry round %v\n", round-1)
+	}
+}
+
+func (c *consoleRenderer) event(ev testEvent) {
+	switch ev.Action {
+	case "build-output":
+		fmt.Fprint(c.w, ev.Output)
+		return
+	case "output":
+		if ev.Test == "" && ev.Package == "" {
+			fmt.Fprintln(c.w, strings.TrimSuffix(ev.Output, "\n"))
+			return
+		}
+	}
+
+	pkg := c.pkg(ev.Package)
+	if ev.Test == "" {
+		switch ev.Action {
+		case "output":
+			if line := strings.TrimSuffix(ev.Output, "\n"); !isFramingLine(line) {
+				pkg.packageOutput = append(pkg.packageOutput, line)
+			}
+		case "pass", "fail", "skip":
+			c.endPackage(ev, pkg)
+		}
+		return
+	}
+
+	switch ev.Action {
+	case "output":
+		if !isFramingLine(strings.TrimSuffix(ev.Output, "\n")) {
+			pkg.output[ev.Test] = append(pkg.output[ev.Test], strings.TrimSuffix(ev.Output, "\n"))
+		}
+	case "pass", "fail", "skip":
+		c.endTest(ev, pkg)
+	}
+}
+
+func (c *consoleRenderer) endTest(ev testEvent, pkg *consolePackage) {
+	output := pkg.output[ev.Test]
+	delete(pkg.output, ev.Test)
+
+	isRoot := !strings.Contains(ev.Test, "/")
+	elapsed := formatElapsed(ev.Elapsed)
+	retry, maxRetries := 0, 0
+	if isRoot {
+		retry, maxRetries = c.retries(testID{pkg: ev.Package, name: ev.Test})
+	}
+
+	switch ev.Action {
+	case "pass":
+		if !isRoot {
+			return
+		}
+		pkg.passed++
+		if retry > 0 {
+			pkg.flaky++
+			c.header(ev.Package, pkg)
+			c.printf(ansiYellow, "--- FLAKY: %v (%v, passed on retry %v/%v)\n", ev.Test, elapsed, retry, maxRetries)
+		}
+	case "skip":
+		if isRoot {
+			pkg.skipped++
+		}
+	case "fail":
+		if isRoot {
+			pkg.failed++
+		}
+		c.header(ev.Package, pkg)
+		if retry > 0 {
+			c.printf(ansiRed, "--- RETRY %v/%v FAIL: %v (%v)\n", retry, maxRetries, ev.Test, elapsed)
+		} else {
+			c.printf(ansiRed, "--- FAIL: %v (%v)\n", ev.Test, elapsed)
+		}
+		for _, line := range output {
+			fmt.Fprintln(c.w, line)
+		}
+	}
+}
+
+func (c *consoleRenderer) endPackage(ev testEvent, pkg *consolePackage) {
+	delete(c.packages, ev.Package)
+
+	// Output of tests that never ended, e.g. because of a panic or a timeout,
+	// and package output, e.g. a panic stack trace or a TestMain failure.
+	unfinished := make([]string, 0, len(pkg.output))
+	for test := range pkg.output {
+		unfinished = append(unfinished, test)
+	}
+	sort.Strings(unfinished)
+	for _, test := range unfinished {
+		c.header(ev.Package, pkg)
+		c.printf(ansiRed, "--- NO RESULT: %v\n", test)
+		for _, line := range pkg.output[test] {
+			fmt.Fprintln(c.w, line)
+		}
+	}
+	if ev.Action == "fail" {
+		for _, line := range pkg.packageOutput {
+			fmt.Fprintln(c.w, line)
+		}
+	}
+
+	counts := fmt.Sprintf("%v passed", pkg.passed)
+	if pkg.failed > 0 {
+		counts += fmt.Sprintf(", %v failed", pkg.failed)
+	}
+	if pkg.skipped > 0 {
+		counts += fmt.Sprintf(", %v skipped", pkg.skipped)
+	}
+	if pkg.flaky > 0 {
+		counts += fmt.Sprintf(", %v flaky", pkg.flaky)
+	}
+
+	switch {
+	case ev.Action == "fail":
+		c.printf(ansiRed, "FAIL  %v (%v) %v\n", ev.Package, counts, formatElapsed(ev.Elapsed))
+	case ev.Action == "skip" || pkg.passed+pkg.skipped == 0:
+		c.printf(ansiDim, "?     %v [no tests]\n", ev.Package)
+	default:
+		c.printf(ansiGreen, "ok    %v (%v) %v\n", ev.Package, counts, formatElapsed(ev.Elapsed))
+	}
+}
+
+// header prints the package name before the first detailed line about its
+// tests.
+func (c *consoleRenderer) header(name string, pkg *consolePackage) {
+	if pkg.headerPrinted {
+		return
+	}
+	pkg.headerPrinted = true
+	c.printf(ansiBold, "=== %v\n", name)
+}
+
+// clusters prints groups of failed tests sharing the cause of failure, so
+// that one broken helper doesn't look like many unrelated failures.
+func (c *consoleRenderer) clusters(clusters []failureCluster) {
+	for _, cluster := range clusters {
+		c.printf(ansiRed, "--- SAME CAUSE: %v tests: %v\n", len(cluster.tests), cluster.cause)
+		for _, test := range cluster.tests {
+			c.printf(ansiDim, "    %v\n", test)
+		}
+	}
+}
+
+// summary prints the final line of the run.
+func (c *consoleRenderer) summary(tests, flaky, failed int, o outcome, elapsed time.Duration) {
+	color := ansiGreen
+	switch o {
+	case outcomeFlaky:
+		color = ansiYellow
+	case outcomePassed:
+	default:
+		color = ansiRed
+	}
+	c.printf(color, "DONE %v tests, %v flaky, %v failed in %v: %v\n",
+		tests, flaky, failed, elapsed.Round(time.Millisecond), o)
+}
+
+func (c *consoleRenderer) pkg(name string) *consolePackage {
+	pkg, ok := c.packages[name]
+	if !ok {
+		pkg = &consolePackage{output: make(map[string][]string)}
+		c.packages[name] = pkg
+	}
+	return pkg
+}
+
+func (c *consoleRenderer) printf(color, format string, args ...any) {
+	if c.color {
+		fmt.Fprint(c.w, color)
+		fmt.Fprintf(c.w, strings.TrimSuffix(format, "\n"), args...)
+		fmt.Fprintln(c.w, ansiReset)
+		return
+	}
+	fmt.Fprintf(c.w, format, args...)
+}
+
+func formatElapsed(seconds float64) string {
+	return fmt.Sprintf("%.2fs", seconds)
+}
@@ -0,0 +1,52 @@
+package retryer
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConsoleRendererMarksRetries(t *testing.T) {
+	output := new(bytes.Buffer)
+	retries := map[string]int{"TestFlaky": 1, "TestFail": 2}
+	renderer := newConsoleRenderer(output, false, func(id testID) (int, int) {
+		return retries[id.name], 2
+	})
+
+	for _, ev := range []testEvent{
+		{Action: "run", Package: "pkg", Test: "TestFlaky"},
+		{Action: "output", Package: "pkg", Test: "TestFlaky", Output: "=== RUN   TestFlaky\n"},
+		{Action: "pass", Package: "pkg", Test: "TestFlaky", Elapsed: 0.01},
+		{Action: "run", Package: "pkg", Test: "TestFail"},
+		{Action: "output", Package: "pkg", Test: "TestFail", Output: "    fail_test.go:10: boom\n"},
+		{Action: "output", Package: "pkg", Test: "TestFail", Output: "--- FAIL: TestFail (0.02s)\n"},
+		{Action: "fail", Package: "pkg", Test: "TestFail", Elapsed: 0.02},
+		{Action: "output", Package: "pkg", Output: "FAIL\n"},
+		{Action: "fail", Package: "pkg", Elapsed: 0.1},
+	} {
+		renderer.event(ev)
+	}
+
+	assert.Equal(t, "=== pkg\n"+
+		"--- FLAKY: TestFlaky (0.01s, passed on retry 1/2)\n"+
+		"--- RETRY 2/2 FAIL: TestFail (0.02s)\n"+
+		"    fail_test.go:10: boom\n"+
+		"FAIL  pkg (1 passed, 1 failed, 1 flaky) 0.10s\n",
+		output.String())
+}
+
+func TestConsoleRendererClusters(t *testing.T) {
+	output := new(bytes.Buffer)
+	renderer := newConsoleRenderer(output, false, nil)
+
+	renderer.clusters([]failureCluster{{
+		cause: "fixture_test.go:12: connection refused",
+		tests: []testID{{pkg: "pkg", name: "TestA"}, {pkg: "pkg", name: "TestB"}},
+	}})
+
+	assert.Equal(t, "--- SAME CAUSE: 2 tests: fixture_test.go:12: connection refused\n"+
+		"    pkg.TestA\n"+
+		"    pkg.TestB\n",
+		output.String())
+}
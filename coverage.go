@@ -0,0 +1,171 @@
+package retryer
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// coverageProfiles gives every test command its own coverage profile, so that
+// retries don't overwrite coverage of the initial run, and merges them into
+// the profile requested with -coverprofile.
+type coverageProfiles struct {
+	path  string
+	dir   string
+	files []string
+}
+
+// newCoverageProfiles returns nil if test arguments don't set -coverprofile.
+func newCoverageProfiles(args testArgs) (*coverageProfiles, error) {
+	path, ok := args.flagValue("coverprofile")
+	if !ok || path == "" {
+		return nil, nil
+	}
+	// go test writes relative profile paths to -outputdir, if it's set.
+	if outputDir, ok := args.flagValue("outputdir"); ok && !filepath.IsAbs(path) {
+		path = filepath.Join(outputDir, path)
+	}
+
+	dir, err := os.MkdirTemp("", "go-test-retryer-cover-*")
+	if err != nil {
+		return nil, errors.Wrap(err, "create coverage profiles directory")
+	}
+	return &coverageProfiles{path: path, dir: dir}, nil
+}
+
+// testArgs returns the test arguments of a command with -coverprofile set to
+// the own profile of the command.
+func (c *coverageProfiles) testArgs(testArgs string, round, command int) (string, error) {
+	args, err := parseTestArgs(testArgs)
+	if err != nil {
+		return "", err
+	}
+	file := filepath.Join(c.dir, fmt.Sprintf("round-%d-%d.out", round, command))
+	c.files = append(c.files, file)
+	return args.withoutFlags("coverprofile").withFlag("coverprofile", file).String(), nil
+}
+
+// addInitialProfile adds the profile that the initial run, whose output is
+// read with -initial-output, wrote to the requested path, so that merging
+// doesn't replace it by coverage of retries. It's copied, since merging
+// overwrites the requested profile.
+func (c *coverageProfiles) addInitialProfile() error {
+	data, err := os.ReadFile(c.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "read coverage profile of initial run")
+	}
+	file := filepath.Join(c.dir, "initial.out")
+	if err := os.WriteFile(file, data, 0o644); err != nil {
+		return errors.Wrap(err, "copy coverage profile of initial run")
+	}
+	c.files = append(c.files, file)
+	return nil
+}
+
+// merge merges profiles of all commands into the requested profile and
+// removes them. Commands that didn't write a profile, e.g. because of a
+// build failure, are skipped.
+func (c *coverageProfiles) merge() (err error) {
+	defer os.RemoveAll(c.dir)
+
+	var readers []io.Reader
+	for _, file := range c.files {
+		f, err := os.Open(file)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return errors.Wrap(err, "open coverage profile")
+		}
+		defer f.Close()
+		readers = append(readers, f)
+	}
+	if len(readers) == 0 {
+		return nil
+	}
+
+	out, err := os.Create(c.path)
+	if err != nil {
+		return errors.Wrap(err, "create coverage profile")
+	}
+	defer func() {
+		if closeErr := out.Close(); err == nil && closeErr != nil {
+			err = errors.Wrap(closeErr, "write coverage profile")
+		}
+	}()
+	return mergeCoverProfiles(out, readers...)
+}
+
+// mergeCoverProfiles writes the union of blocks of the profiles. Counts of a
+// block are combined by maximum in set mode and summed in count and atomic
+// modes.
+func mergeCoverProfiles(w io.Writer, profiles ...io.Reader) error {
+	mode := ""
+	var blocks []string
+	counts := make(map[string]int64)
+
+	for _, profile := range profiles {
+		scanner := bufio.NewScanner(profile)
+		first := true
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" {
+				continue
+			}
+			if first {
+				first = false
+				profileMode, ok := strings.CutPrefix(line, "mode: ")
+				if !ok {
+					return errors.Errorf("coverage profile doesn't start with mode line: %q", line)
+				}
+				if mode != "" && mode != profileMode {
+					return errors.Errorf("coverage profiles have different modes: %v and %v", mode, profileMode)
+				}
+				mode = profileMode
+				continue
+			}
+
+			i := strings.LastIndexByte(line, ' ')
+			if i < 0 {
+				return errors.Errorf("invalid coverage profile line: %q", line)
+			}
+			block := line[:i]
+			count, err := strconv.ParseInt(line[i+1:], 10, 64)
+			if err != nil {
+				return errors.Errorf("invalid coverage profile line: %q", line)
+			}
+
+			prev, seen := counts[block]
+			if !seen {
+				blocks = append(blocks, block)
+			}
+			if mode == "set" {
+				counts[block] = max(prev, count)
+			} else {
+				counts[block] = prev + count
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return errors.Wrap(err, "read coverage profile")
+		}
+	}
+
+	if mode == "" {
+		return nil
+	}
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "mode: %v\n", mode)
+	for _, block := range blocks {
+		fmt.Fprintf(bw, "%v %v\n", block, counts[block])
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,111 @@
+package retryer
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMergeCoverProfiles(t *testing.T) {
+	testCases := []struct {
+		name     string
+		profiles []string
+		expected string
+	}{
+		{
+			name: "SetModeTakesMax",
+			profiles: []string{
+				"mode: set\na.go:1.1,2.2 1 1\na.go:3.1,4.2 2 0\n",
+				"mode: set\na.go:3.1,4.2 2 1\nb.go:1.1,2.2 1 0\n",
+			},
+			expected: "mode: set\na.go:1.1,2.2 1 1\na.go:3.1,4.2 2 1\nb.go:1.1,2.2 1 0\n",
+		},
+		{
+			name: "CountModeSums",
+			profiles: []string{
+				"mode: count\na.go:1.1,2.2 1 3\n",
+				"mode: count\na.go:1.1,2.2 1 2\n",
+			},
+			expected: "mode: count\na.go:1.1,2.2 1 5\n",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var profiles []io.Reader
+			for _, profile := range tc.profiles {
+				profiles = append(profiles, strings.NewReader(profile))
+			}
+			output := new(bytes.Buffer)
+			require.NoError(t, mergeCoverProfiles(output, profiles...))
+			assert.Equal(t, tc.expected, output.String())
+		})
+	}
+}
+
+func TestMergeCoverProfilesDifferentModes(t *testing.T) {
+	err := mergeCoverProfiles(new(bytes.Buffer),
+		strings.NewReader("mode: set\n"), strings.NewReader("mode: count\n"))
+	assert.Error(t, err)
+}
+
+func TestCoverageProfileOfRetriedRun(t *testing.T) {
+	testConfigPath := createTestConfigFile(t, "flaky_test_failures_left: 1")
+	defer os.Remove(testConfigPath)
+	coverPath := filepath.Join(t.TempDir(), "cover.out")
+
+	command := exec.Command(retryerBinaryPath, "-retries-per-test=1", "-shell=/bin/bash",
+		"-test-args="+coverageTestArgs(coverPath, testConfigPath))
+	require.NoError(t, command.Run())
+
+	requireFullAttemptCoverage(t, coverPath)
+}
+
+func TestCoverageProfileOfInitialOutput(t *testing.T) {
+	testConfigPath := createTestConfigFile(t, "flaky_test_failures_left: 1")
+	defer os.Remove(testConfigPath)
+	dir := t.TempDir()
+	coverPath := filepath.Join(dir, "cover.out")
+	outputPath := filepath.Join(dir, "output.log")
+	testArgs := coverageTestArgs(coverPath, testConfigPath)
+
+	_, err := runCommand("/bin/bash", fmt.Sprintf("go test %v > %v", testArgs, outputPath), io.Discard, io.Discard)
+	require.Error(t, err)
+	command := exec.Command(retryerBinaryPath, "-retries-per-test=1", "-shell=/bin/bash",
+		"-initial-output="+outputPath, "-test-args="+testArgs)
+	require.NoError(t, command.Run())
+
+	requireFullAttemptCoverage(t, coverPath)
+}
+
+func coverageTestArgs(coverPath, testConfigPath string) string {
+	return fmt.Sprintf(
+		"-v -count=1 -covermode=count -coverprofile=%v -run=^TestCoverageOfFlaky$ github.com/zcapitalz/go-test-retryer/test -config-path=%v",
+		coverPath, testConfigPath)
+}
+
+// requireFullAttemptCoverage checks that the profile covers both the failed
+// attempt of the initial run and the passed retry, which run different
+// branches.
+func requireFullAttemptCoverage(t *testing.T, coverPath string) {
+	profile, err := os.ReadFile(coverPath)
+	require.NoError(t, err)
+	var blocks []string
+	for _, line := range strings.Split(string(profile), "\n") {
+		if strings.Contains(line, "/test/attempt.go:") {
+			blocks = append(blocks, line)
+		}
+	}
+	require.Len(t, blocks, 3, string(profile))
+	for _, block := range blocks {
+		assert.NotRegexp(t, ` 0$`, block)
+	}
+}
@@ -0,0 +1,224 @@
+package retryer
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// directivePrefix starts retry directives in comments of _test.go files,
+// e.g. `//retryer:retries=3 reason="uses real network"` above a test
+// function or a t.Run call.
+const directivePrefix = "//retryer:"
+
+// retryDirective sets the maximum retries of a test in its source.
+type retryDirective struct {
+	retries int
+	reason  string
+	pos     token.Position
+}
+
+func (d retryDirective) String() string {
+	s := fmt.Sprintf("{directive retries=%v", d.retries)
+	if d.reason != "" {
+		s += fmt.Sprintf(" reason=%q", d.reason)
+	}
+	return s + " at " + d.pos.String() + "}"
+}
+
+// packageDirectives are retry directives of a package by test name, with
+// subtest names as go test reports them, e.g. "TestX/some_case".
+type packageDirectives map[string]retryDirective
+
+// directive returns the retry directive of a root test, or, if the test has
+// none, the directive allowing most retries among its subtests that failed in
+// the last attempt.
+func (r *Retryer) directive(test testID) (retryDirective, bool) {
+	if !r.cfg.directives || test.pkg == "" || test.name == "" {
+		return retryDirective{}, false
+	}
+	directives := r.packageDirectives(test.pkg)
+	if d, ok := directives[test.name]; ok {
+		return d, true
+	}
+	var directive retryDirective
+	found := false
+	for _, subtest := range r.lastFailedSubtests[testID{pkg: test.pkg, name: test.name}] {
+		if d, ok := directives[subtest]; ok && (!found || d.retries > directive.retries) {
+			directive, found = d, true
+		}
+	}
+	return directive, found
+}
+
+// packageDirectives returns the retry directives of the package, parsing its
+// _test.go files on first use. Directives that aren't attached to a test are
+// reported once.
+func (r *Retryer) packageDirectives(pkg string) packageDirectives {
+	if directives, ok := r.directives[pkg]; ok {
+		return directives
+	}
+	r.directives[pkg] = nil
+
+	listArgs := append([]string{"list"}, testBuildArgs(r.testArgs).listFlags...)
+	listArgs = append(listArgs, "-f", "{{.Dir}}{{range .TestGoFiles}}\n{{.}}{{end}}{{range .XTestGoFiles}}\n{{.}}{{end}}", pkg)
+	output, err := goCommand(listArgs...)
+	if err != nil {
+		r.logger.Warn("Couldn't find test files to read retry directives", "package", pkg, "error", err)
+		return nil
+	}
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	files := make([]string, 0, len(lines)-1)
+	for _, name := range lines[1:] {
+		files = append(files, filepath.Join(lines[0], name))
+	}
+
+	directives, warnings := parseDirectives(files)
+	for _, warning := range warnings {
+		r.logger.Warn("Invalid retry directive", "package", pkg, "error", warning)
+	}
+	if len(directives) > 0 {
+		r.logger.Debug("Retry directives", "package", pkg, "directives", directives)
+	}
+	r.directives[pkg] = directives
+	return directives
+}
+
+// parseDirectives collects retry directives of test functions and of t.Run
+// calls with literal subtest names in the files. Directives attached to
+// anything else and directives that can't be parsed are returned as
+// warnings.
+func parseDirectives(files []string) (packageDirectives, []string) {
+	directives := make(packageDirectives)
+	var warnings []string
+	fset := token.NewFileSet()
+	for _, path := range files {
+		file, err := parser.ParseFile(fset, path, nil, parser.ParseComments)
+		if err != nil {
+			warnings = append(warnings, err.Error())
+			continue
+		}
+
+		used := make(map[*ast.Comment]struct{})
+		add := func(name string, groups ...*ast.CommentGroup) {
+			for _, group := range groups {
+				if group == nil {
+					continue
+				}
+				for _, comment := range group.List {
+					if !strings.HasPrefix(comment.Text, directivePrefix) {
+						continue
+					}
+					used[comment] = struct{}{}
+					d, err := parseDirective(comment.Text)
+					if err != nil {
+						warnings = append(warnings, fmt.Sprintf("%v: %v", fset.Position(comment.Pos()), err))
+						continue
+					}
+					d.pos = fset.Position(comment.Pos())
+					directives[name] = d
+				}
+			}
+		}
+
+		comments := ast.NewCommentMap(fset, file, file.Comments)
+		for _, decl := range file.Decls {
+			fn, ok := decl.(*ast.FuncDecl)
+			if !ok || !isTestFunc(fn) {
+				continue
+			}
+			add(fn.Name.Name, fn.Doc)
+			addSubtestDirectives(fn.Body, fn.Name.Name, comments, add)
+		}
+
+		for _, group := range file.Comments {
+			for _, comment := range group.List {
+				if _, ok := used[comment]; !ok && strings.HasPrefix(comment.Text, directivePrefix) {
+					warnings = append(warnings, fmt.Sprintf(
+						"%v: directive isn't attached to a test function or a t.Run call with a literal name",
+						fset.Position(comment.Pos())))
+				}
+			}
+		}
+	}
+	return directives, warnings
+}
+
+// addSubtestDirectives adds directives of t.Run calls in the body of a test
+// or subtest.
+func addSubtestDirectives(body ast.Node, parent string, comments ast.CommentMap, add func(string, ...*ast.CommentGroup)) {
+	if body == nil {
+		return
+	}
+	ast.Inspect(body, func(n ast.Node) bool {
+		stmt, ok := n.(*ast.ExprStmt)
+		if !ok {
+			return true
+		}
+		call, ok := stmt.X.(*ast.CallExpr)
+		if !ok || len(call.Args) != 2 {
+			return true
+		}
+		sel, ok := call.Fun.(*ast.SelectorExpr)
+		if !ok || sel.Sel.Name != "Run" {
+			return true
+		}
+		lit, ok := call.Args[0].(*ast.BasicLit)
+		if !ok || lit.Kind != token.STRING {
+			return true
+		}
+		name, err := strconv.Unquote(lit.Value)
+		if err != nil {
+			return true
+		}
+
+		// go test replaces spaces in subtest names by underscores.
+		name = parent + "/" + strings.ReplaceAll(name, " ", "_")
+		add(name, comments[stmt]...)
+		if fn, ok := call.Args[1].(*ast.FuncLit); ok {
+			addSubtestDirectives(fn.Body, name, comments, add)
+		}
+		return false
+	})
+}
+
+// parseDirective parses `//retryer:retries=N reason="..."`.
+func parseDirective(text string) (retryDirective, error) {
+	words, err := splitShellWords(strings.TrimPrefix(text, directivePrefix))
+	if err != nil {
+		return retryDirective{}, err
+	}
+	var d retryDirective
+	hasRetries := false
+	for _, word := range words {
+		key, value, ok := strings.Cut(word.value, "=")
+		if !ok {
+			return retryDirective{}, fmt.Errorf("expected key=value, got %q", word.value)
+		}
+		switch key {
+		case "retries":
+			d.retries, err = strconv.Atoi(value)
+			if err != nil || d.retries < 0 {
+				return retryDirective{}, fmt.Errorf("retries should be a non-negative number, got %q", value)
+			}
+			hasRetries = true
+		case "reason":
+			d.reason = value
+		default:
+			return retryDirective{}, fmt.Errorf("unknown directive key %q", key)
+		}
+	}
+	if !hasRetries {
+		return retryDirective{}, fmt.Errorf("directive doesn't set retries")
+	}
+	return d, nil
+}
+
+func isTestFunc(fn *ast.FuncDecl) bool {
+	return fn.Recv == nil && strings.HasPrefix(fn.Name.Name, "Test") && fn.Name.Name != "TestMain" &&
+		fn.Type.Params != nil && len(fn.Type.Params.List) == 1
+}
@@ -0,0 +1,53 @@
+package retryer
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseDirectives(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "x_test.go")
+	require.NoError(t, os.WriteFile(path, []byte(`package x
+
+import "testing"
+
+// TestNetwork talks to a real server.
+//
+//retryer:retries=3 reason="uses real network"
+func TestNetwork(t *testing.T) {
+	//retryer:retries=1
+	t.Run("slow case", func(t *testing.T) {
+		//retryer:retries=5
+		t.Run("nested", func(t *testing.T) {})
+	})
+	for _, name := range []string{"a"} {
+		//retryer:retries=2
+		t.Run(name, func(t *testing.T) {})
+	}
+}
+
+//retryer:retries=2
+func helper() {}
+
+//retryer:retries=many
+func TestInvalid(t *testing.T) {}
+`), 0o644))
+
+	directives, warnings := parseDirectives([]string{path})
+
+	require.Len(t, directives, 3)
+	assert.Equal(t, 3, directives["TestNetwork"].retries)
+	assert.Equal(t, "uses real network", directives["TestNetwork"].reason)
+	assert.Equal(t, 7, directives["TestNetwork"].pos.Line)
+	assert.Equal(t, 1, directives["TestNetwork/slow_case"].retries)
+	assert.Equal(t, 5, directives["TestNetwork/slow_case/nested"].retries)
+	assert.Equal(t, []string{
+		path + `:23:1: retries should be a non-negative number, got "many"`,
+		path + ":15:3: directive isn't attached to a test function or a t.Run call with a literal name",
+		path + ":20:1: directive isn't attached to a test function or a t.Run call with a literal name",
+	}, warnings)
+}
@@ -0,0 +1,99 @@
+package retryer
+
+import (
+	"fmt"
+	"strings"
+)
+
+// printRetryPlan prints the retry round that would follow the initial run:
+// the tests retried in every package with their retry numbers, failed tests
+// that wouldn't be retried and why, the commands and the retries budget.
+// Later rounds depend on results of retries, so they can't be planned.
+func (r *Retryer) printRetryPlan() {
+	testsToRetry := r.selectTestsForRetry()
+	packagesToRetry := r.selectPackagesForRetry()
+
+	w := r.stdout
+	if r.tooManyFailures {
+		fmt.Fprintln(w, "No retries planned: too many failures")
+		return
+	}
+	if len(testsToRetry) == 0 && len(packagesToRetry) == 0 {
+		fmt.Fprintln(w, "No retries planned")
+	} else {
+		fmt.Fprintf(w, "Retry round %v:\n", r.round)
+	}
+	var pkg string
+	for i, test := range testsToRetry {
+		if i == 0 || test.pkg != pkg {
+			pkg = test.pkg
+			fmt.Fprintf(w, "  package %v\n", packageName(pkg))
+		}
+		kind := r.lastFailureKinds[test]
+		fmt.Fprintf(w, "    %v (%v): retry %v/%v\n",
+			testID{name: test.name, cpu: test.cpu}, kind, r.totalRetriesPerTest[test], r.maxRetriesForFailureKind(test, kind))
+	}
+	for _, pkg := range packagesToRetry {
+		fmt.Fprintf(w, "  package %v as a whole: retry %v/%v\n",
+			packageName(pkg), r.totalRetriesPerPackage[pkg], r.maxRetriesForTest(testID{pkg: pkg}))
+	}
+
+	var notRetried []string
+	for _, test := range r.lastFailedTests {
+		if _, ok := r.lastRetriedTests[test]; ok {
+			continue
+		}
+		reason := "total retries exhausted"
+		if r.isTooManyFailuresPackage(test.pkg) {
+			reason = "too many failures in package"
+		} else if duration, ok := r.testsTooSlowToRetry[test]; ok {
+			reason = fmt.Sprintf("last attempt took %v, longer than max retry test duration", duration)
+		} else if duration, ok := r.testsOverTimeBudget[test]; ok {
+			reason = fmt.Sprintf("estimated %v doesn't fit in retry time budget", duration)
+		} else if _, ok := r.deterministicFailures[test]; ok {
+			reason = fmt.Sprintf("failed %v times with identical output", r.identicalFailures[test])
+		} else if kind, ok := r.testsNotRetriedByKind[test]; ok {
+			reason = fmt.Sprintf("retries of %v failures exhausted", kind)
+		} else if r.totalRetriesPerTest[test] >= r.maxRetriesForTest(test) {
+			reason = "retries per test exhausted"
+		}
+		notRetried = append(notRetried, fmt.Sprintf("  %v (%v): %v", test, r.lastFailureKinds[test], reason))
+	}
+	if len(notRetried) > 0 {
+		fmt.Fprintln(w, "Not retried:")
+		fmt.Fprintln(w, strings.Join(notRetried, "\n"))
+	}
+
+	if len(testsToRetry) > 0 || len(packagesToRetry) > 0 {
+		if r.cfg.prebuild {
+			// Binaries aren't built in dry runs, their paths aren't known.
+			fmt.Fprintln(w, "Commands, run with test binaries built by go test -c:")
+		} else {
+			fmt.Fprintln(w, "Commands:")
+		}
+		for _, testArgs := range r.retryTestArgs(testsToRetry, packagesToRetry) {
+			fmt.Fprintf(w, "  %v %v\n", r.cfg.testCommandName, testArgs)
+		}
+	}
+
+	planned := len(testsToRetry) + len(packagesToRetry)
+	if r.cfg.isTotalRetriesLimitEnabled() {
+		fmt.Fprintf(w, "Total retries: %v planned, %v of %v left after the round\n",
+			planned, r.totalRetriesLeft, r.cfg.maxTotalRetries)
+	} else {
+		fmt.Fprintf(w, "Total retries: %v planned, unlimited\n", planned)
+	}
+	if r.cfg.retryTimeBudget != 0 {
+		fmt.Fprintf(w, "Retry time: %v planned, %v of %v left after the round\n",
+			r.cfg.retryTimeBudget-r.retryTimeLeft, r.retryTimeLeft, r.cfg.retryTimeBudget)
+	}
+}
+
+// packageName returns the package name to print, which is unknown if test
+// output didn't name it.
+func packageName(pkg string) string {
+	if pkg == "" {
+		return "(unknown)"
+	}
+	return pkg
+}
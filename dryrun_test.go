@@ -0,0 +1,76 @@
+package retryer
+
+import (
+	"bytes"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDryRun(t *testing.T) {
+	plan, _ := dryRun(t, Config{
+		maxRetriesPerTest:  2,
+		maxTotalRetries:    2,
+		failureKindRetries: failureKindRetries{failureKindPanic: 0},
+	}, "=== RUN   TestA\n"+
+		"--- FAIL: TestA (0.00s)\n"+
+		"=== RUN   TestB\n"+
+		"panic: boom\n"+
+		"--- FAIL: TestB (0.00s)\n"+
+		"=== RUN   TestC\n"+
+		"--- FAIL: TestC (0.00s)\n"+
+		"FAIL\n"+
+		"FAIL\tpkg\t0.01s\n")
+
+	assert.Equal(t, `Retry round 1:
+  package pkg
+    TestA (fail): retry 1/2
+    TestC (fail): retry 1/2
+Not retried:
+  pkg.TestB (panic): retries of panic failures exhausted
+Commands:
+  go test -v pkg "--test.run=^((TestA)|(TestC))$"
+Total retries: 2 planned, 0 of 2 left after the round
+`, plan)
+}
+
+func TestDryRunPrebuild(t *testing.T) {
+	plan, log := dryRun(t, Config{
+		maxRetriesPerTest: 1,
+		testArgs:          "-v ./test",
+		prebuild:          true,
+		logLevel:          slog.LevelDebug,
+	}, "=== RUN   TestFlaky\n"+
+		"--- FAIL: TestFlaky (0.00s)\n"+
+		"FAIL\n"+
+		"FAIL\tgithub.com/zcapitalz/go-test-retryer/test\t0.01s\n")
+
+	assert.Contains(t, plan, `Commands, run with test binaries built by go test -c:
+  go test -v github.com/zcapitalz/go-test-retryer/test "--test.run=^((TestFlaky))$"
+`)
+	assert.NotContains(t, log, "Building test binary")
+}
+
+// dryRun prints the retry plan for failures of the initial output with the
+// config and returns the plan and the retryer log. Test arguments are
+// "-v ./..." unless the config sets them.
+func dryRun(t *testing.T, cfg Config, initialOutput string) (plan, log string) {
+	cfg.initialOutput = filepath.Join(t.TempDir(), "output.log")
+	require.NoError(t, os.WriteFile(cfg.initialOutput, []byte(initialOutput), 0o644))
+	cfg.dryRun = true
+	cfg.exitCodes = defaultExitCodes()
+	cfg.testCommandName = "go test"
+	if cfg.testArgs == "" {
+		cfg.testArgs = "-v ./..."
+	}
+	cfg.shellPath = "/bin/false"
+
+	stdout := new(bytes.Buffer)
+	stderr := new(bytes.Buffer)
+	require.NoError(t, NewRetryer(cfg, stdout, stderr).Run())
+	return stdout.String(), stderr.String()
+}
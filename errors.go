@@ -14,3 +14,14 @@ type InvalidParameterError struct {
 }
 
 func (err InvalidParameterError) Error() string { return err.message }
+
+// InternalError is an error of the retryer itself, e.g. a failure to start
+// the test command or to parse its output.
+type InternalError struct {
+	err      error
+	exitCode int
+}
+
+func (err InternalError) Error() string { return err.err.Error() }
+func (err InternalError) Unwrap() error { return err.err }
+func (err InternalError) ExitCode() int { return err.exitCode }
@@ -0,0 +1,84 @@
+package retryer
+
+import (
+	"flag"
+	"log/slog"
+	"strings"
+)
+
+// NewExecConfigFromArgs returns the config of the go test -exec wrapper,
+// invoked by go test as "go-test-retryer-exec [flags] binary [binary flags]".
+// The test binary runs in place of the test command and failed tests are
+// retried by running it again with -test.run selecting them, so go test only
+// sees the final result.
+func NewExecConfigFromArgs(args []string) (Config, error) {
+	cfg := Config{
+		failureKindRetries: make(failureKindRetries),
+		exitCodes:          defaultExitCodes(),
+		onBuildError:       onBuildErrorContinue,
+		formatMismatch:     formatMismatchWarn,
+		console:            consoleRaw,
+		shellPath:          "/bin/sh",
+	}
+
+	flag.IntVar(&cfg.maxRetriesPerTest, "retries-per-test", 0, "maximum retries per test")
+	flag.IntVar(&cfg.maxTotalRetries, "total-retries", 0, "maximum retries for all tests of the test binary")
+	flag.Var(cfg.failureKindRetries, "failure-kind-retries", "maximum retries per test by failure kind, e.g. race=0,timeout=1")
+	flag.Var(cfg.exitCodes, "exit-codes", "exit codes by outcome, e.g. flaky=0,failed=last")
+	flag.BoolVar(&cfg.strict, "strict", false, "fail if any test passed only after retries")
+	flag.TextVar(&cfg.logLevel, "log-level", slog.LevelWarn, "minimum level of retryer logs: debug, info, warn or error")
+	flag.StringVar(&cfg.logFormat, "log-format", logFormatText, "format of retryer logs: text or json")
+	flag.StringVar(&cfg.logFile, "log-file", "", "file to write retryer logs to instead of stderr")
+	flag.StringVar(&cfg.shellPath, "shell", cfg.shellPath, "path to shell")
+	// Flags end at the test binary path, flags after it belong to the binary.
+	flag.CommandLine.Parse(args[1:])
+
+	// The environment configures go-test-retryer too, variables of options
+	// the wrapper doesn't have are left to it.
+	sources := make(map[string]string)
+	flag.Visit(func(f *flag.Flag) {
+		sources[f.Name] = sourceFlag
+	})
+	if err := applyEnvironment(sources, true); err != nil {
+		return Config{}, err
+	}
+	cfg.optionSources = effectiveOptions(sources)
+
+	if flag.NArg() == 0 {
+		return Config{}, InvalidParameterError{"Test binary path is required"}
+	}
+	if cfg.maxTotalRetries < 0 {
+		return Config{}, cfg.invalidOption("Retries amount should be non-negative", "total-retries")
+	}
+	if cfg.maxRetriesPerTest < 0 {
+		return Config{}, cfg.invalidOption("Retries amount should be non-negative", "retries-per-test")
+	}
+	if cfg.logFormat != logFormatText && cfg.logFormat != logFormatJSON {
+		return Config{}, cfg.invalidOption("Log format should be one of: text, json", "log-format")
+	}
+	for _, retries := range cfg.failureKindRetries {
+		if retries < 0 {
+			return Config{}, cfg.invalidOption("Retries amount should be non-negative", "failure-kind-retries")
+		}
+	}
+
+	cfg.testCommandName = shellQuote(flag.Arg(0))
+	binaryArgs := make([]string, 0, flag.NArg())
+	for _, arg := range flag.Args()[1:] {
+		binaryArgs = append(binaryArgs, shellQuote(arg))
+	}
+	cfg.testArgs = strings.Join(binaryArgs, " ")
+
+	// Without -v go test runs binaries without -test.v, and passed retries
+	// wouldn't be reported. go test shows output of passed packages only when
+	// it tests the current directory, as it does with -v.
+	testArgs, err := parseTestArgs(cfg.testArgs)
+	if err != nil {
+		return Config{}, err
+	}
+	if !testArgs.hasFlag("v") {
+		cfg.testArgs = testArgs.withArg(testArg{raw: "-test.v=true", value: "-test.v=true"}).String()
+	}
+
+	return cfg, nil
+}
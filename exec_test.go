@@ -0,0 +1,79 @@
+package retryer
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jstemmer/go-junit-report/v2/gtr"
+	"github.com/jstemmer/go-junit-report/v2/parser/gotest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExec(t *testing.T) {
+	testCases := []struct {
+		name             string
+		env              string
+		execArgs         string
+		testCfg          string
+		expectedExitCode int
+		expectedResults  []gtr.Result
+	}{
+		{
+			name:             "FlakyTestRetried",
+			execArgs:         "-retries-per-test=2",
+			testCfg:          "flaky_test_failures_left: 1",
+			expectedExitCode: 0,
+			expectedResults:  []gtr.Result{gtr.Fail, gtr.Pass},
+		},
+		{
+			name:             "RetriesExhausted",
+			execArgs:         "-retries-per-test=2",
+			testCfg:          "flaky_test_failures_left: 3",
+			expectedExitCode: 1,
+			expectedResults:  []gtr.Result{gtr.Fail, gtr.Fail, gtr.Fail},
+		},
+		{
+			// Variables of options the wrapper doesn't have are ignored.
+			name:             "RetriesFromEnvironment",
+			env:              "GO_TEST_RETRYER_RETRIES_PER_TEST=1 GO_TEST_RETRYER_JSON=true",
+			testCfg:          "flaky_test_failures_left: 1",
+			expectedExitCode: 0,
+			expectedResults:  []gtr.Result{gtr.Fail, gtr.Pass},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			testConfigPath := createTestConfigFile(t, tc.testCfg)
+			defer os.Remove(testConfigPath)
+			logPath := filepath.Join(t.TempDir(), "retryer.log")
+
+			stdout := new(bytes.Buffer)
+			command := fmt.Sprintf(
+				`%v go test -json -count=1 -run=^TestFlaky$ -exec="%v %v -log-level=debug -log-file=%v" github.com/zcapitalz/go-test-retryer/test -config-path=%v`,
+				tc.env, retryerExecBinaryPath, tc.execArgs, logPath, testConfigPath)
+			debugLogf(t, "Command:\n%v\n", command)
+			exitCode, _ := runCommand("/bin/bash", command, stdout, new(bytes.Buffer))
+			if retryerLog, err := os.ReadFile(logPath); err == nil {
+				debugLogf(t, "Retryer log:\n%s\n", retryerLog)
+			}
+			assert.Equal(t, tc.expectedExitCode, exitCode)
+
+			report, err := gotest.NewJSONParser().Parse(stdout)
+			require.NoError(t, err)
+			var results []gtr.Result
+			for _, pkg := range report.Packages {
+				for _, test := range pkg.Tests {
+					results = append(results, test.Result)
+				}
+			}
+			assert.Equal(t, tc.expectedResults, results)
+		})
+	}
+}
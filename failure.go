@@ -0,0 +1,161 @@
+package retryer
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jstemmer/go-junit-report/v2/gtr"
+)
+
+type failureKind string
+
+const (
+	failureKindFail    failureKind = "fail"
+	failureKindPanic   failureKind = "panic"
+	failureKindTimeout failureKind = "timeout"
+	failureKindRace    failureKind = "race"
+	failureKindCrash   failureKind = "crash"
+)
+
+var failureKinds = []failureKind{
+	failureKindFail,
+	failureKindPanic,
+	failureKindTimeout,
+	failureKindRace,
+	failureKindCrash,
+}
+
+var (
+	regexRaceReport   = regexp.MustCompile(`^WARNING: DATA RACE$|race detected during execution of test$`)
+	regexTimeoutPanic = regexp.MustCompile(`^panic: test timed out after`)
+	regexPanic        = regexp.MustCompile(`^panic: `)
+	regexFatalError   = regexp.MustCompile(`^fatal error: `)
+	regexStackFrame   = regexp.MustCompile(`\.(Test[^.(/]*)(\.func[\d.]+)?\(`)
+)
+
+func parseFailureKind(s string) (failureKind, error) {
+	for _, kind := range failureKinds {
+		if string(kind) == s {
+			return kind, nil
+		}
+	}
+	return "", fmt.Errorf("unknown failure kind %q", s)
+}
+
+// failureKindRetries limits retries per test for failures of specific kinds.
+// It implements flag.Value and is set from "kind=retries,..." strings.
+type failureKindRetries map[failureKind]int
+
+func (v failureKindRetries) String() string {
+	parts := make([]string, 0, len(v))
+	for kind, retries := range v {
+		parts = append(parts, fmt.Sprintf("%v=%v", kind, retries))
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ",")
+}
+
+func (v failureKindRetries) Set(s string) error {
+	for _, part := range strings.Split(s, ",") {
+		if part == "" {
+			continue
+		}
+		kindStr, retriesStr, ok := strings.Cut(part, "=")
+		if !ok {
+			return fmt.Errorf("expected kind=retries, got %q", part)
+		}
+		kind, err := parseFailureKind(kindStr)
+		if err != nil {
+			return err
+		}
+		retries, err := strconv.Atoi(retriesStr)
+		if err != nil {
+			return fmt.Errorf("invalid retries for %v: %w", kind, err)
+		}
+		v[kind] = retries
+	}
+	return nil
+}
+
+// classifyPackageFailures returns the failure kind of every failed root test
+// of the package. The kind is derived from the output of the test and its
+// subtests, and from the package output, where the go test runner prints
+// panics that happen after the test has been reported as failed.
+func classifyPackageFailures(pkg gtr.Package) map[string]failureKind {
+	kinds := make(map[string]failureKind)
+
+	rootTests := filter(pkg.Tests, isRootTest)
+	failedRootTests := filter(rootTests, isFailedTest)
+	if len(failedRootTests) == 0 {
+		return kinds
+	}
+
+	packagePanicked := anyLineMatches(pkg.Output, regexPanic)
+	packageTimedOut := anyLineMatches(pkg.Output, regexTimeoutPanic)
+	panickedTests := panickedTestsFromOutput(pkg.Output)
+	if packagePanicked && len(panickedTests) == 0 {
+		lastFailedTest := failedRootTests[len(failedRootTests)-1]
+		panickedTests[lastFailedTest.Name] = struct{}{}
+	}
+
+	for _, test := range failedRootTests {
+		output := testOutputWithSubtests(pkg, test.Name)
+		_, panicked := panickedTests[test.Name]
+
+		switch {
+		case anyLineMatches(output, regexRaceReport):
+			kinds[test.Name] = failureKindRace
+		case anyLineMatches(output, regexTimeoutPanic),
+			test.Result == gtr.Unknown && packageTimedOut:
+			kinds[test.Name] = failureKindTimeout
+		case anyLineMatches(output, regexPanic), panicked:
+			kinds[test.Name] = failureKindPanic
+		case anyLineMatches(output, regexFatalError), test.Result == gtr.Unknown:
+			kinds[test.Name] = failureKindCrash
+		default:
+			kinds[test.Name] = failureKindFail
+		}
+	}
+
+	return kinds
+}
+
+func panickedTestsFromOutput(output []string) map[string]struct{} {
+	tests := make(map[string]struct{})
+	panicked := false
+	for _, line := range output {
+		if regexPanic.MatchString(line) {
+			panicked = true
+			continue
+		}
+		if !panicked {
+			continue
+		}
+		if matches := regexStackFrame.FindStringSubmatch(line); matches != nil {
+			tests[matches[1]] = struct{}{}
+		}
+	}
+	return tests
+}
+
+func testOutputWithSubtests(pkg gtr.Package, rootTestName string) []string {
+	var output []string
+	for _, test := range pkg.Tests {
+		if test.Name == rootTestName || strings.HasPrefix(test.Name, rootTestName+"/") {
+			output = append(output, test.Output...)
+		}
+	}
+	return output
+}
+
+func anyLineMatches(lines []string, r *regexp.Regexp) bool {
+	for _, line := range lines {
+		if r.MatchString(line) {
+			return true
+		}
+	}
+	return false
+}
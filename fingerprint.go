@@ -0,0 +1,81 @@
+package retryer
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"regexp"
+	"strings"
+
+	"github.com/jstemmer/go-junit-report/v2/gtr"
+)
+
+// fingerprintNormalizers replace parts of test output that differ between
+// runs of a test failing for the same reason.
+var fingerprintNormalizers = []struct {
+	regex       *regexp.Regexp
+	replacement string
+}{
+	{regexp.MustCompile(`\d{4}[-/]\d{2}[-/]\d{2}([T ]\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}:?\d{2})?)?`), "<time>"},
+	{regexp.MustCompile(`\d{2}:\d{2}:\d{2}(\.\d+)?`), "<time>"},
+	{regexp.MustCompile(`\b\d+(\.\d+)?(ns|µs|us|ms|s|m|h)\b`), "<duration>"},
+	{regexp.MustCompile(`0x[0-9a-fA-F]+`), "<addr>"},
+	{regexp.MustCompile(`goroutine \d+`), "goroutine <id>"},
+}
+
+// failureFingerprint identifies the failure output of a test regardless of
+// timestamps, durations, addresses and goroutine IDs, so that failures of a
+// broken test look identical in every attempt while failures of a flaky test
+// usually don't.
+func failureFingerprint(output []string) string {
+	h := sha256.New()
+	for _, line := range output {
+		for _, n := range fingerprintNormalizers {
+			line = n.regex.ReplaceAllString(line, n.replacement)
+		}
+		h.Write([]byte(strings.TrimSpace(line)))
+		h.Write([]byte{'\n'})
+	}
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+// fingerprintsFromReport returns failure fingerprints of failed root tests of
+// the report, computed from the output of the tests and their subtests.
+// Tests without output, e.g. crashed ones, get no fingerprint: there is
+// nothing to tell their failures apart by.
+func fingerprintsFromReport(report gtr.Report) map[testID]string {
+	fingerprints := make(map[testID]string)
+	for _, pkg := range report.Packages {
+		for _, test := range filter(pkg.Tests, isRootTest, isFailedTest) {
+			if output := testOutputWithSubtests(pkg, test.Name); len(output) > 0 {
+				fingerprints[testID{pkg: pkg.Name, name: test.Name}] = failureFingerprint(output)
+			}
+		}
+	}
+	return fingerprints
+}
+
+// recordFingerprint counts consecutive failed attempts of the test with the
+// same fingerprint. An attempt without fingerprint starts a new sequence.
+func (r *Retryer) recordFingerprint(test testID, fingerprint string) {
+	if fingerprint != "" && r.lastFingerprints[test] == fingerprint {
+		r.identicalFailures[test]++
+	} else {
+		r.identicalFailures[test] = 1
+	}
+	r.lastFingerprints[test] = fingerprint
+}
+
+// isDeterministicFailure reports whether the last -max-identical-failures
+// attempts of the test failed with identical output, so retrying it would
+// only waste the retries budget. Such tests are remembered for the summary.
+func (r *Retryer) isDeterministicFailure(test testID) bool {
+	if r.cfg.maxIdenticalFailures == 0 || r.identicalFailures[test] < r.cfg.maxIdenticalFailures {
+		return false
+	}
+	if _, ok := r.deterministicFailures[test]; !ok {
+		r.deterministicFailures[test] = r.lastFingerprints[test]
+		r.logger.Debug("Identical failures, not retrying",
+			"test", test, "attempts", r.identicalFailures[test], "fingerprint", r.lastFingerprints[test])
+	}
+	return true
+}
@@ -0,0 +1,25 @@
+package retryer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFailureFingerprint(t *testing.T) {
+	first := failureFingerprint([]string{
+		"    db_test.go:42: 2024-03-01T10:15:30.123Z query failed after 1.5s",
+		"    db_test.go:43: conn 0xc000123456 closed by goroutine 17",
+	})
+	second := failureFingerprint([]string{
+		"    db_test.go:42: 2024-03-02T11:00:01.456Z query failed after 2.75s",
+		"    db_test.go:43: conn 0xc000abcdef closed by goroutine 233",
+	})
+	other := failureFingerprint([]string{
+		"    db_test.go:42: 2024-03-01T10:15:30.123Z query failed after 1.5s",
+		"    db_test.go:44: unexpected row count",
+	})
+
+	assert.Equal(t, first, second)
+	assert.NotEqual(t, first, other)
+}
@@ -0,0 +1,40 @@
+package retryer
+
+import "fmt"
+
+const (
+	formatMismatchWarn  = "warn"
+	formatMismatchError = "error"
+)
+
+// handleFormatMismatch reports test output whose format differs from the one
+// configured with -json. The output is parsed in the detected format, so with
+// the warn policy the run goes on; the warning is logged once.
+func (r *Retryer) handleFormatMismatch(detected outputFormat) error {
+	message := fmt.Sprintf(
+		"Test output format is %v, but -json=%v; check that -json is set in both retryer flags and test arguments",
+		detected, r.cfg.testOutputTypeJSON)
+	if r.cfg.formatMismatch == formatMismatchError {
+		return InvalidParameterError{message}
+	}
+	if !r.formatMismatchWarned {
+		r.logger.Warn(message)
+		r.formatMismatchWarned = true
+	}
+	return nil
+}
+
+// addFormatFlags adds -json, or -v without -json, to the test arguments, so
+// that results of all tests, including passed ones, are in the output.
+func (r *Retryer) addFormatFlags() {
+	name := "v"
+	if r.cfg.testOutputTypeJSON {
+		name = "json"
+	}
+	if r.testArgs.hasFlag(name) {
+		return
+	}
+	r.testArgs = r.testArgs.withBoolFlag(name)
+	r.cfg.testArgs = r.testArgs.String()
+	r.logger.Debug("Added test flag", "flag", "-"+name)
+}
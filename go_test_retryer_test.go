@@ -7,29 +7,73 @@ import (
 	"io"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"regexp"
 	"strings"
+	"sync"
 	"testing"
 
 	"github.com/jstemmer/go-junit-report/v2/gtr"
+	"github.com/jstemmer/go-junit-report/v2/parser/gotest"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
-var debug bool
+var (
+	debug                 bool
+	retryerBinaryPath     string
+	retryerExecBinaryPath string
+)
 
 func init() {
 	flag.BoolVar(&debug, "debug", false, "print debug info")
 }
 
+// TestMain builds the retryer binaries once, so that test cases run them
+// directly and observe their real exit codes, which go run would replace by 1.
+func TestMain(m *testing.M) {
+	flag.Parse()
+
+	binaryDir, err := os.MkdirTemp("", "go-test-retryer-*")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "create binary dir:", err)
+		os.Exit(1)
+	}
+	retryerBinaryPath = filepath.Join(binaryDir, "go-test-retryer")
+	retryerExecBinaryPath = filepath.Join(binaryDir, "go-test-retryer-exec")
+
+	for path, pkg := range map[string]string{
+		retryerBinaryPath:     "./cmd/go-test-retryer",
+		retryerExecBinaryPath: "./cmd/go-test-retryer-exec",
+	} {
+		build := exec.Command("go", "build", "-o", path, pkg)
+		build.Stdout = os.Stdout
+		build.Stderr = os.Stderr
+		if err := build.Run(); err != nil {
+			fmt.Fprintln(os.Stderr, "build retryer:", err)
+			os.RemoveAll(binaryDir)
+			os.Exit(1)
+		}
+	}
+
+	exitCode := m.Run()
+	os.RemoveAll(binaryDir)
+	os.Exit(exitCode)
+}
+
 func Test(t *testing.T) {
 	for _, tc := range testCases {
 		t.Run(tc.name, testFromTestCase(tc))
+		if tc.plainOnly {
+			continue
+		}
 
 		tc.name += "JSON"
 		tc.retryerCfg.testOutputTypeJSON = true
 		tc.retryerCfg.testArgs += " -json"
-		for i, command := range tc.expectedCommands {
+		plainCommands := tc.expectedCommands
+		tc.expectedCommands = make([]string, len(plainCommands))
+		for i, command := range plainCommands {
 			tc.expectedCommands[i] = command + " -json"
 		}
 		t.Run(tc.name, testFromTestCase(tc))
@@ -55,15 +99,19 @@ func testFromTestCase(tc testCase) func(*testing.T) {
 		stdout := new(bytes.Buffer)
 		stderr := new(bytes.Buffer)
 		output := new(buffer)
+		logPath := filepath.Join(t.TempDir(), "retryer.log")
 		retryerArgs := fmt.Sprintf(
-			`-json=%v -total-retries=%v -retries-per-test=%v -test-command-name="%v" -verbose=%v -shell=%v`,
+			`-json=%v -total-retries=%v -retries-per-test=%v -test-command-name="%v" -log-level=debug -log-file=%v -shell=%v`,
 			tc.retryerCfg.testOutputTypeJSON, tc.retryerCfg.maxTotalRetries, tc.retryerCfg.maxRetriesPerTest,
-			tc.retryerCfg.testCommandName, tc.retryerCfg.verbose, tc.retryerCfg.shellPath)
-		command := fmt.Sprintf(`go run ./cmd/go-test-retryer/main.go %v -test-args="%v"`, retryerArgs, escapeQuotes(tc.retryerCfg.testArgs))
+			tc.retryerCfg.testCommandName, logPath, tc.retryerCfg.shellPath)
+		if tc.retryerArgs != "" {
+			retryerArgs += " " + tc.retryerArgs
+		}
+		command := fmt.Sprintf(`%v %v -test-args="%v"`, retryerBinaryPath, retryerArgs, escapeQuotes(tc.retryerCfg.testArgs))
 		debugLogf(t, "Command:\n%v\n", command)
 		exitCode, err := runCommand(
 			tc.retryerCfg.shellPath,
-			fmt.Sprintf(`go run ./cmd/go-test-retryer/main.go %v -test-args="%v"`, retryerArgs, escapeQuotes(tc.retryerCfg.testArgs)),
+			command,
 			io.MultiWriter(stdout, output),
 			io.MultiWriter(stderr, output))
 		if tc.retryerCfg.testOutputTypeJSON {
@@ -73,6 +121,14 @@ func testFromTestCase(tc testCase) func(*testing.T) {
 			_, ok := err.(*exec.ExitError)
 			require.True(t, ok, fmt.Sprintf("command execution error: %v", err))
 		}
+		retryerLog, err := os.ReadFile(logPath)
+		if err == nil {
+			debugLogf(t, "Retryer log:\n%s\n", retryerLog)
+		}
+		for pattern, count := range tc.expectedLogLines {
+			matches := regexp.MustCompile(`(?m)^.*`+pattern+`.*$`).FindAllString(string(retryerLog), -1)
+			assert.Len(t, matches, count, "retryer log lines matching %q", pattern)
+		}
 		assert.Equal(t, tc.expectedExitCode, exitCode)
 
 		checkStdout(t, strings.NewReader(expectedStdoutStr), stdout, tc.retryerCfg.testOutputTypeJSON)
@@ -119,9 +175,9 @@ func runCommand(shellPath, command string, stdout, stderr io.Writer) (int, error
 
 func checkStdout(t *testing.T, expectedStdout, actualStdout io.Reader, outputTypeJSON bool) {
 	if outputTypeJSON {
-		actualReport, err := parseTestReport(actualStdout, outputTypeJSON)
+		actualReport, err := gotest.NewJSONParser().Parse(actualStdout)
 		require.NoError(t, err)
-		expectedReport, err := parseTestReport(expectedStdout, outputTypeJSON)
+		expectedReport, err := gotest.NewJSONParser().Parse(expectedStdout)
 		require.NoError(t, err)
 
 		requireEqualReports(t, expectedReport, actualReport)
@@ -153,11 +209,17 @@ func requireEqualReportPackages(t *testing.T, expectedPackage, actualPackage gtr
 		require.Equal(t, expectedTest.Result, actualTest.Result)
 		for i, expectedOutputStr := range expectedTest.Output {
 			require.Greater(t, len(actualTest.Output), i)
-			require.Equal(t, expectedOutputStr, actualTest.Output[i])
+			require.Equal(t, normalizeOutputLine(expectedOutputStr), normalizeOutputLine(actualTest.Output[i]))
 		}
 	}
 }
 
+// normalizeOutputLine hides memory addresses printed in panic stack traces
+// and race detector reports, since they differ from run to run.
+func normalizeOutputLine(line string) string {
+	return regexp.MustCompile(`0x[0-9a-f]+`).ReplaceAllString(line, "0x?")
+}
+
 func createTestConfigFile(t *testing.T, config string) (configPath string) {
 	f, err := os.CreateTemp("", "test_config_*.yaml")
 	require.NoError(t, err)
@@ -178,6 +240,24 @@ func escapeQuotes(str string) string {
 	return regexp.MustCompile(`"`).ReplaceAllString(str, `\"`)
 }
 
+// buffer is a bytes.Buffer safe for concurrent writes of stdout and stderr
+// of a command.
+type buffer struct {
+	buffer bytes.Buffer
+	locker sync.Mutex
+}
+
+func (b *buffer) Write(p []byte) (n int, err error) {
+	b.locker.Lock()
+	n, err = b.buffer.Write(p)
+	b.locker.Unlock()
+	return
+}
+
+func (b *buffer) String() string {
+	return b.buffer.String()
+}
+
 func debugLogf(t *testing.T, format string, args ...any) {
 	if debug {
 		t.Logf(format, args...)
@@ -4,9 +4,15 @@ package retryer
 type testCase struct {
 	name             string
 	retryerCfg       Config
+	retryerArgs      string
 	testCfg          string
 	expectedExitCode int
 	expectedCommands []string
+	// expectedLogLines maps patterns to the number of retryer log lines
+	// matching them, e.g. to check which commands were actually run.
+	expectedLogLines map[string]int
+	// plainOnly disables the generated json mode test case.
+	plainOnly bool
 }
 
 // Add only test cases for plain mode (-json=false).
@@ -22,7 +28,6 @@ var testCases = []testCase{
 			maxTotalRetries:    0,
 			testCommandName:    "go test",
 			testArgs:           "-v -run=^TestSuccess$ -count=1 github.com/zcapitalz/go-test-retryer/test",
-			verbose:            false,
 			shellPath:          "/bin/bash",
 		},
 		expectedExitCode: 0,
@@ -38,7 +43,6 @@ var testCases = []testCase{
 			maxTotalRetries:    1,
 			testCommandName:    "go test",
 			testArgs:           "-v -run=^TestSuccess$ -count=1 github.com/zcapitalz/go-test-retryer/test",
-			verbose:            false,
 			shellPath:          "/bin/bash",
 		},
 		expectedExitCode: 0,
@@ -54,7 +58,6 @@ var testCases = []testCase{
 			maxTotalRetries:    0,
 			testCommandName:    "go test",
 			testArgs:           "-v -run=^TestFail$ -count=1 github.com/zcapitalz/go-test-retryer/test",
-			verbose:            false,
 			shellPath:          "/bin/bash",
 		},
 		expectedExitCode: 1,
@@ -70,7 +73,6 @@ var testCases = []testCase{
 			maxTotalRetries:    1,
 			testCommandName:    "go test",
 			testArgs:           "-v -run=^TestFail$ -count=1 github.com/zcapitalz/go-test-retryer/test",
-			verbose:            false,
 			shellPath:          "/bin/bash",
 		},
 		expectedExitCode: 1,
@@ -86,7 +88,6 @@ var testCases = []testCase{
 			maxTotalRetries:    1,
 			testCommandName:    "go test",
 			testArgs:           "-v -run=^TestFail$ -count=1 github.com/zcapitalz/go-test-retryer/test",
-			verbose:            false,
 			shellPath:          "/bin/bash",
 		},
 		expectedExitCode: 1,
@@ -103,7 +104,6 @@ var testCases = []testCase{
 			maxTotalRetries:    0,
 			testCommandName:    "go test",
 			testArgs:           "-v -run=^TestFail$ -count=1 github.com/zcapitalz/go-test-retryer/test",
-			verbose:            false,
 			shellPath:          "/bin/bash",
 		},
 		expectedExitCode: 1,
@@ -120,7 +120,6 @@ var testCases = []testCase{
 			maxTotalRetries:    0,
 			testCommandName:    "go test",
 			testArgs:           "-v -count=1 github.com/zcapitalz/go-test-retryer/test/notcompilable",
-			verbose:            false,
 			shellPath:          "/bin/bash",
 		},
 		expectedExitCode: 1,
@@ -136,7 +135,6 @@ var testCases = []testCase{
 			maxTotalRetries:    1,
 			testCommandName:    "go test",
 			testArgs:           "-v -count=1 github.com/zcapitalz/go-test-retryer/test/notcompilable",
-			verbose:            false,
 			shellPath:          "/bin/bash",
 		},
 		expectedExitCode: 1,
@@ -152,7 +150,6 @@ var testCases = []testCase{
 			maxTotalRetries:    2,
 			testCommandName:    "go test",
 			testArgs:           "-v -count=1 -run=^TestFlaky$ github.com/zcapitalz/go-test-retryer/test",
-			verbose:            false,
 			shellPath:          "/bin/bash",
 		},
 		testCfg:          "flaky_test_failures_left: 2",
@@ -171,7 +168,6 @@ var testCases = []testCase{
 			maxTotalRetries:    1,
 			testCommandName:    "go test",
 			testArgs:           "-v -count=1 -run=^TestFlaky$ github.com/zcapitalz/go-test-retryer/test",
-			verbose:            false,
 			shellPath:          "/bin/bash",
 		},
 		testCfg:          "flaky_test_failures_left: 2",
@@ -189,7 +185,6 @@ var testCases = []testCase{
 			maxTotalRetries:    2,
 			testCommandName:    "go test",
 			testArgs:           `-v -count=1 -run="^(TestFlaky|TestFail)$" github.com/zcapitalz/go-test-retryer/test`,
-			verbose:            false,
 			shellPath:          "/bin/bash",
 		},
 		testCfg:          "flaky_test_failures_left: 1",
@@ -199,4 +194,497 @@ var testCases = []testCase{
 			`go test -v -count=1 -run="^(TestFlaky|TestFail)" github.com/zcapitalz/go-test-retryer/test`,
 		},
 	},
+	{
+		name: "RaceTestNotRetriedByFailureKindPolicy",
+		retryerCfg: Config{
+			testOutputTypeJSON: false,
+			maxRetriesPerTest:  1,
+			maxTotalRetries:    1,
+			testCommandName:    "go test",
+			testArgs:           "-v -race -count=1 -run=^TestRace$ github.com/zcapitalz/go-test-retryer/test",
+			shellPath:          "/bin/bash",
+		},
+		retryerArgs:      "-failure-kind-retries=race=0",
+		expectedExitCode: 1,
+		expectedCommands: []string{
+			"go test -v -race -count=1 -run=^TestRace$ github.com/zcapitalz/go-test-retryer/test",
+		},
+	},
+	{
+		name: "TimedOutTestRetried",
+		retryerCfg: Config{
+			testOutputTypeJSON: false,
+			maxRetriesPerTest:  1,
+			maxTotalRetries:    1,
+			testCommandName:    "go test",
+			testArgs:           "-v -timeout=1s -count=1 -run=^TestTimeout$ github.com/zcapitalz/go-test-retryer/test",
+			shellPath:          "/bin/bash",
+		},
+		expectedExitCode: 1,
+		expectedCommands: []string{
+			"go test -v -timeout=1s -count=1 -run=^TestTimeout$ github.com/zcapitalz/go-test-retryer/test",
+			"go test -v -timeout=1s -count=1 -run=^TestTimeout$ github.com/zcapitalz/go-test-retryer/test",
+		},
+	},
+	{
+		name: "PackageFailureRetried",
+		retryerCfg: Config{
+			testOutputTypeJSON: false,
+			maxRetriesPerTest:  1,
+			maxTotalRetries:    1,
+			testCommandName:    "go test",
+			testArgs:           "-v -count=1 github.com/zcapitalz/go-test-retryer/test/crash",
+			shellPath:          "/bin/bash",
+		},
+		retryerArgs:      "-retry-failed-packages",
+		testCfg:          "package_failures_left: 1",
+		expectedExitCode: 0,
+		expectedCommands: []string{
+			"go test -v -count=1 github.com/zcapitalz/go-test-retryer/test/crash",
+			"go test -v -count=1 github.com/zcapitalz/go-test-retryer/test/crash",
+		},
+	},
+	{
+		name: "PackageFailureNotRetried",
+		retryerCfg: Config{
+			testOutputTypeJSON: false,
+			maxRetriesPerTest:  1,
+			maxTotalRetries:    1,
+			testCommandName:    "go test",
+			testArgs:           "-v -count=1 github.com/zcapitalz/go-test-retryer/test/crash",
+			shellPath:          "/bin/bash",
+		},
+		testCfg:          "package_failures_left: 1",
+		expectedExitCode: 1,
+		expectedCommands: []string{
+			"go test -v -count=1 github.com/zcapitalz/go-test-retryer/test/crash",
+		},
+	},
+	{
+		name: "MissingTestsRun",
+		retryerCfg: Config{
+			testOutputTypeJSON: false,
+			maxRetriesPerTest:  1,
+			maxTotalRetries:    1,
+			testCommandName:    "go test",
+			testArgs:           "-v -count=1 -failfast \"-run=^(TestFlaky|TestAfterFlaky)$\" github.com/zcapitalz/go-test-retryer/test",
+			shellPath:          "/bin/bash",
+		},
+		retryerArgs:      "-run-missing-tests",
+		testCfg:          "flaky_test_failures_left: 1",
+		expectedExitCode: 0,
+		expectedCommands: []string{
+			"go test -v -count=1 -failfast \"-run=^(TestFlaky|TestAfterFlaky)$\" github.com/zcapitalz/go-test-retryer/test",
+			"go test -v -count=1 -failfast \"-run=^(TestFlaky|TestAfterFlaky)$\" github.com/zcapitalz/go-test-retryer/test \"--test.run=^((TestFlaky)|(TestAfterFlaky))$\"",
+		},
+	},
+	{
+		name: "FlakyTestRepeatedByCount",
+		retryerCfg: Config{
+			testOutputTypeJSON: false,
+			maxRetriesPerTest:  1,
+			maxTotalRetries:    1,
+			testCommandName:    "go test",
+			testArgs:           "-v -count=2 -run=^TestFlaky$ github.com/zcapitalz/go-test-retryer/test",
+			shellPath:          "/bin/bash",
+		},
+		testCfg:          "flaky_test_failures_left: 1",
+		expectedExitCode: 0,
+		expectedCommands: []string{
+			"go test -v -count=2 -run=^TestFlaky$ github.com/zcapitalz/go-test-retryer/test",
+			"go test -v -count=2 -run=^TestFlaky$ github.com/zcapitalz/go-test-retryer/test \"--test.run=^((TestFlaky))$\"",
+		},
+	},
+	{
+		name: "FlakyTestRepeatedByCPU",
+		retryerCfg: Config{
+			testOutputTypeJSON: false,
+			maxRetriesPerTest:  1,
+			maxTotalRetries:    1,
+			testCommandName:    "go test",
+			testArgs:           "-v -count=1 -cpu=1,2 -run=^TestFlaky$ github.com/zcapitalz/go-test-retryer/test",
+			shellPath:          "/bin/bash",
+		},
+		testCfg:          "flaky_test_failures_left: 1",
+		expectedExitCode: 0,
+		expectedCommands: []string{
+			"go test -v -count=1 -cpu=1,2 -run=^TestFlaky$ github.com/zcapitalz/go-test-retryer/test",
+			"go test -v -count=1 -cpu=1,2 -run=^TestFlaky$ github.com/zcapitalz/go-test-retryer/test \"--test.run=^((TestFlaky))$\"",
+		},
+	},
+	{
+		name: "FormatMismatchDetected",
+		retryerCfg: Config{
+			testOutputTypeJSON: false,
+			maxRetriesPerTest:  1,
+			maxTotalRetries:    1,
+			testCommandName:    "go test",
+			testArgs:           "-json -count=1 -run=^TestFlaky$ github.com/zcapitalz/go-test-retryer/test",
+			shellPath:          "/bin/bash",
+		},
+		testCfg:          "flaky_test_failures_left: 1",
+		expectedExitCode: 0,
+		expectedCommands: []string{
+			"go test -json -count=1 -run=^TestFlaky$ github.com/zcapitalz/go-test-retryer/test",
+			"go test -json -count=1 -run=^TestFlaky$ github.com/zcapitalz/go-test-retryer/test \"--test.run=^((TestFlaky))$\"",
+		},
+		plainOnly: true,
+	},
+	{
+		name: "FormatFlagsAdded",
+		retryerCfg: Config{
+			testOutputTypeJSON: false,
+			maxRetriesPerTest:  1,
+			maxTotalRetries:    1,
+			testCommandName:    "go test",
+			testArgs:           "-count=1 -run=^TestFlaky$ github.com/zcapitalz/go-test-retryer/test",
+			shellPath:          "/bin/bash",
+		},
+		retryerArgs:      "-add-format-flags",
+		testCfg:          "flaky_test_failures_left: 1",
+		expectedExitCode: 0,
+		expectedCommands: []string{
+			"go test -count=1 -run=^TestFlaky$ github.com/zcapitalz/go-test-retryer/test -v",
+			"go test -count=1 -run=^TestFlaky$ github.com/zcapitalz/go-test-retryer/test -v \"--test.run=^((TestFlaky))$\"",
+		},
+	},
+	{
+		name: "PrettyConsole",
+		retryerCfg: Config{
+			testOutputTypeJSON: false,
+			maxRetriesPerTest:  1,
+			maxTotalRetries:    1,
+			testCommandName:    "go test",
+			testArgs:           "-count=1 -run=^TestFlaky$ github.com/zcapitalz/go-test-retryer/test",
+			shellPath:          "/bin/bash",
+		},
+		retryerArgs:      "-console=pretty -color=never",
+		testCfg:          "flaky_test_failures_left: 1",
+		expectedExitCode: 0,
+		expectedCommands: []string{
+			"go test -count=1 -run=^TestFlaky$ github.com/zcapitalz/go-test-retryer/test -json",
+			"go test -count=1 -run=^TestFlaky$ github.com/zcapitalz/go-test-retryer/test -json \"--test.run=^((TestFlaky))$\"",
+		},
+		plainOnly: true,
+	},
+	{
+		name: "PrebuiltBinaryRetries",
+		retryerCfg: Config{
+			testOutputTypeJSON: false,
+			maxRetriesPerTest:  2,
+			maxTotalRetries:    2,
+			testCommandName:    "go test",
+			testArgs:           "-v -count=1 -run=^TestFlaky$ github.com/zcapitalz/go-test-retryer/test",
+			shellPath:          "/bin/bash",
+		},
+		retryerArgs:      "-prebuild",
+		testCfg:          "flaky_test_failures_left: 2",
+		expectedExitCode: 0,
+		expectedCommands: []string{
+			"go test -v -count=1 -run=^TestFlaky$ github.com/zcapitalz/go-test-retryer/test",
+			"go test -v -count=1 -run=^TestFlaky$ github.com/zcapitalz/go-test-retryer/test",
+			"go test -v -count=1 -run=^TestFlaky$ github.com/zcapitalz/go-test-retryer/test",
+		},
+		expectedLogLines: map[string]int{
+			`Building test binary`:               1,
+			`Running command.* go test .*-run=`:  1,
+			`Running command.*\.test\S* -test\.`: 2,
+		},
+	},
+	{
+		name: "InitialOutputRead",
+		retryerCfg: Config{
+			testOutputTypeJSON: false,
+			maxRetriesPerTest:  2,
+			maxTotalRetries:    2,
+			testCommandName:    "go test",
+			testArgs:           "-v -count=1 \"-run=^(TestSuccess|TestFail)$\" github.com/zcapitalz/go-test-retryer/test",
+			shellPath:          "/bin/bash",
+		},
+		retryerArgs:      `-initial-output=- < <(go test -v -count=1 "-run=^(TestSuccess|TestFail)$" github.com/zcapitalz/go-test-retryer/test)`,
+		expectedExitCode: 1,
+		expectedCommands: []string{
+			"go test -v -count=1 \"-run=^(TestSuccess|TestFail)$\" github.com/zcapitalz/go-test-retryer/test \"--test.run=^((TestFail))$\"",
+			"go test -v -count=1 \"-run=^(TestSuccess|TestFail)$\" github.com/zcapitalz/go-test-retryer/test \"--test.run=^((TestFail))$\"",
+		},
+	},
+	{
+		name: "ConfigFileOptions",
+		retryerCfg: Config{
+			testOutputTypeJSON: false,
+			maxRetriesPerTest:  2,
+			maxTotalRetries:    2,
+			testCommandName:    "go test",
+			testArgs:           "-v -count=1 -run=^TestFlaky$ github.com/zcapitalz/go-test-retryer/test",
+			shellPath:          "/bin/bash",
+		},
+		retryerArgs:      `-config=<(echo '{failure-kind-retries: {fail: 0}, profiles: {flaky: {failure-kind-retries: {fail: 2}}}}')`,
+		testCfg:          "flaky_test_failures_left: 2",
+		expectedExitCode: 1,
+		expectedCommands: []string{
+			"go test -v -count=1 -run=^TestFlaky$ github.com/zcapitalz/go-test-retryer/test",
+		},
+	},
+	{
+		name: "ConfigFileProfile",
+		retryerCfg: Config{
+			testOutputTypeJSON: false,
+			maxRetriesPerTest:  2,
+			maxTotalRetries:    2,
+			testCommandName:    "go test",
+			testArgs:           "-v -count=1 -run=^TestFlaky$ github.com/zcapitalz/go-test-retryer/test",
+			shellPath:          "/bin/bash",
+		},
+		retryerArgs:      `-config=<(echo '{failure-kind-retries: {fail: 0}, profiles: {flaky: {failure-kind-retries: {fail: 2}}}}') -profile=flaky`,
+		testCfg:          "flaky_test_failures_left: 2",
+		expectedExitCode: 0,
+		expectedCommands: []string{
+			"go test -v -count=1 -run=^TestFlaky$ github.com/zcapitalz/go-test-retryer/test",
+			"go test -v -count=1 -run=^TestFlaky$ github.com/zcapitalz/go-test-retryer/test",
+			"go test -v -count=1 -run=^TestFlaky$ github.com/zcapitalz/go-test-retryer/test",
+		},
+	},
+	{
+		name: "OverrideRules",
+		retryerCfg: Config{
+			testOutputTypeJSON: false,
+			maxRetriesPerTest:  0,
+			maxTotalRetries:    0,
+			testCommandName:    "go test",
+			testArgs:           "-v -count=1 -run=^TestFlaky$ github.com/zcapitalz/go-test-retryer/test",
+			shellPath:          "/bin/bash",
+		},
+		retryerArgs:      `-overrides='[{package: ./..., retries: 1}, {package: ./test, test: ^TestFl, retries: 2, timeout: 1m}]'`,
+		testCfg:          "flaky_test_failures_left: 2",
+		expectedExitCode: 0,
+		expectedCommands: []string{
+			"go test -v -count=1 -run=^TestFlaky$ github.com/zcapitalz/go-test-retryer/test",
+			"go test -v -count=1 -run=^TestFlaky$ github.com/zcapitalz/go-test-retryer/test \"--test.timeout=1m0s\" \"--test.run=^((TestFlaky))$\"",
+			"go test -v -count=1 -run=^TestFlaky$ github.com/zcapitalz/go-test-retryer/test \"--test.timeout=1m0s\" \"--test.run=^((TestFlaky))$\"",
+		},
+	},
+	{
+		name: "TooManyFailures",
+		retryerCfg: Config{
+			testOutputTypeJSON: false,
+			maxRetriesPerTest:  2,
+			maxTotalRetries:    0,
+			testCommandName:    "go test",
+			testArgs:           "-v -count=1 \"-run=^(TestSuccess|TestFail)$\" github.com/zcapitalz/go-test-retryer/test",
+			shellPath:          "/bin/bash",
+		},
+		retryerArgs:      "-max-failure-percent=40",
+		expectedExitCode: 1,
+		expectedCommands: []string{
+			"go test -v -count=1 \"-run=^(TestSuccess|TestFail)$\" github.com/zcapitalz/go-test-retryer/test",
+		},
+	},
+	{
+		name: "IdenticalFailures",
+		retryerCfg: Config{
+			testOutputTypeJSON: false,
+			maxRetriesPerTest:  3,
+			maxTotalRetries:    0,
+			testCommandName:    "go test",
+			testArgs:           "-v -count=1 -run=^TestFail$ github.com/zcapitalz/go-test-retryer/test",
+			shellPath:          "/bin/bash",
+		},
+		retryerArgs:      "-max-identical-failures=2",
+		expectedExitCode: 1,
+		expectedCommands: []string{
+			"go test -v -count=1 -run=^TestFail$ github.com/zcapitalz/go-test-retryer/test",
+			"go test -v -count=1 -run=^TestFail$ github.com/zcapitalz/go-test-retryer/test \"--test.run=^((TestFail))$\"",
+		},
+	},
+	{
+		name: "PassThresholdConsecutive",
+		retryerCfg: Config{
+			testOutputTypeJSON: false,
+			maxRetriesPerTest:  3,
+			maxTotalRetries:    0,
+			testCommandName:    "go test",
+			testArgs:           "-v -count=1 -run=^TestFlaky$ github.com/zcapitalz/go-test-retryer/test",
+			shellPath:          "/bin/bash",
+		},
+		retryerArgs:      "-pass-threshold=2",
+		testCfg:          "flaky_test_failures_left: 2",
+		expectedExitCode: 0,
+		expectedCommands: []string{
+			"go test -v -count=1 -run=^TestFlaky$ github.com/zcapitalz/go-test-retryer/test",
+			"go test -v -count=1 -run=^TestFlaky$ github.com/zcapitalz/go-test-retryer/test \"--test.count=2\" \"--test.run=^((TestFlaky))$\"",
+			"go test -v -count=1 -run=^TestFlaky$ github.com/zcapitalz/go-test-retryer/test \"--test.count=2\" \"--test.run=^((TestFlaky))$\"",
+		},
+	},
+	{
+		name: "PassThresholdMajority",
+		retryerCfg: Config{
+			testOutputTypeJSON: false,
+			maxRetriesPerTest:  3,
+			maxTotalRetries:    0,
+			testCommandName:    "go test",
+			testArgs:           "-v -count=1 -run=^TestFlaky$ github.com/zcapitalz/go-test-retryer/test",
+			shellPath:          "/bin/bash",
+		},
+		retryerArgs:      "-pass-threshold=2 -pass-policy=majority",
+		testCfg:          "flaky_test_failures_left: 2",
+		expectedExitCode: 0,
+		expectedCommands: []string{
+			"go test -v -count=1 -run=^TestFlaky$ github.com/zcapitalz/go-test-retryer/test",
+			"go test -v -count=1 -run=^TestFlaky$ github.com/zcapitalz/go-test-retryer/test \"--test.count=3\" \"--test.run=^((TestFlaky))$\"",
+		},
+	},
+	{
+		name: "RetryDirectiveDisabledByDefault",
+		retryerCfg: Config{
+			testOutputTypeJSON: false,
+			maxRetriesPerTest:  0,
+			maxTotalRetries:    0,
+			testCommandName:    "go test",
+			testArgs:           "-v -count=1 -run=^TestDirectiveFlaky$ github.com/zcapitalz/go-test-retryer/test",
+			shellPath:          "/bin/bash",
+		},
+		testCfg:          "flaky_test_failures_left: 2",
+		expectedExitCode: 1,
+		expectedCommands: []string{
+			"go test -v -count=1 -run=^TestDirectiveFlaky$ github.com/zcapitalz/go-test-retryer/test",
+		},
+	},
+	{
+		name: "RetryDirective",
+		retryerCfg: Config{
+			testOutputTypeJSON: false,
+			maxRetriesPerTest:  0,
+			maxTotalRetries:    0,
+			testCommandName:    "go test",
+			testArgs:           "-v -count=1 -run=^TestDirectiveFlaky$ github.com/zcapitalz/go-test-retryer/test",
+			shellPath:          "/bin/bash",
+		},
+		retryerArgs:      "-directives",
+		testCfg:          "flaky_test_failures_left: 2",
+		expectedExitCode: 0,
+		expectedCommands: []string{
+			"go test -v -count=1 -run=^TestDirectiveFlaky$ github.com/zcapitalz/go-test-retryer/test",
+			"go test -v -count=1 -run=^TestDirectiveFlaky$ github.com/zcapitalz/go-test-retryer/test \"--test.run=^((TestDirectiveFlaky))$\"",
+			"go test -v -count=1 -run=^TestDirectiveFlaky$ github.com/zcapitalz/go-test-retryer/test \"--test.run=^((TestDirectiveFlaky))$\"",
+		},
+	},
+	{
+		name: "RetryDirectiveOfSubtest",
+		retryerCfg: Config{
+			testOutputTypeJSON: false,
+			maxRetriesPerTest:  0,
+			maxTotalRetries:    0,
+			testCommandName:    "go test",
+			testArgs:           "-v -count=1 -run=^TestDirectiveFlakySubtest$ github.com/zcapitalz/go-test-retryer/test",
+			shellPath:          "/bin/bash",
+		},
+		retryerArgs:      "-directives",
+		testCfg:          "flaky_test_failures_left: 2",
+		expectedExitCode: 0,
+		expectedCommands: []string{
+			"go test -v -count=1 -run=^TestDirectiveFlakySubtest$ github.com/zcapitalz/go-test-retryer/test",
+			"go test -v -count=1 -run=^TestDirectiveFlakySubtest$ github.com/zcapitalz/go-test-retryer/test \"--test.run=^((TestDirectiveFlakySubtest))$\"",
+			"go test -v -count=1 -run=^TestDirectiveFlakySubtest$ github.com/zcapitalz/go-test-retryer/test \"--test.run=^((TestDirectiveFlakySubtest))$\"",
+		},
+	},
+	{
+		name: "VetFailure",
+		retryerCfg: Config{
+			testOutputTypeJSON: false,
+			maxRetriesPerTest:  1,
+			maxTotalRetries:    1,
+			testCommandName:    "go test",
+			testArgs:           "-v -count=1 github.com/zcapitalz/go-test-retryer/test/vet",
+			shellPath:          "/bin/bash",
+		},
+		expectedExitCode: 3,
+		expectedCommands: []string{
+			"go test -v -count=1 github.com/zcapitalz/go-test-retryer/test/vet",
+		},
+	},
+	{
+		name: "NotCompilableAndFailedTestContinue",
+		retryerCfg: Config{
+			testOutputTypeJSON: false,
+			maxRetriesPerTest:  1,
+			maxTotalRetries:    1,
+			testCommandName:    "go test",
+			testArgs:           "-v -count=1 -run=^TestFail$ github.com/zcapitalz/go-test-retryer/test/notcompilable github.com/zcapitalz/go-test-retryer/test",
+			shellPath:          "/bin/bash",
+		},
+		expectedExitCode: 1,
+		expectedCommands: []string{
+			"go test -v -count=1 -run=^TestFail$ github.com/zcapitalz/go-test-retryer/test/notcompilable github.com/zcapitalz/go-test-retryer/test",
+			"go test -v -count=1 -run=^TestFail$ github.com/zcapitalz/go-test-retryer/test",
+		},
+	},
+	{
+		name: "NotCompilableAndFailedTestAbort",
+		retryerCfg: Config{
+			testOutputTypeJSON: false,
+			maxRetriesPerTest:  1,
+			maxTotalRetries:    1,
+			testCommandName:    "go test",
+			testArgs:           "-v -count=1 -run=^TestFail$ github.com/zcapitalz/go-test-retryer/test/notcompilable github.com/zcapitalz/go-test-retryer/test",
+			shellPath:          "/bin/bash",
+		},
+		retryerArgs:      "-on-build-error=abort",
+		expectedExitCode: 1,
+		expectedCommands: []string{
+			"go test -v -count=1 -run=^TestFail$ github.com/zcapitalz/go-test-retryer/test/notcompilable github.com/zcapitalz/go-test-retryer/test",
+		},
+	},
+	{
+		name: "FlakyTestStrict",
+		retryerCfg: Config{
+			testOutputTypeJSON: false,
+			maxRetriesPerTest:  1,
+			maxTotalRetries:    1,
+			testCommandName:    "go test",
+			testArgs:           "-v -count=1 -run=^TestFlaky$ github.com/zcapitalz/go-test-retryer/test",
+			shellPath:          "/bin/bash",
+		},
+		retryerArgs:      "-strict",
+		testCfg:          "flaky_test_failures_left: 1",
+		expectedExitCode: 1,
+		expectedCommands: []string{
+			"go test -v -count=1 -run=^TestFlaky$ github.com/zcapitalz/go-test-retryer/test",
+			"go test -v -count=1 -run=^TestFlaky$ github.com/zcapitalz/go-test-retryer/test",
+		},
+	},
+	{
+		name: "FlakyTestExitCode",
+		retryerCfg: Config{
+			testOutputTypeJSON: false,
+			maxRetriesPerTest:  1,
+			maxTotalRetries:    1,
+			testCommandName:    "go test",
+			testArgs:           "-v -count=1 -run=^TestFlaky$ github.com/zcapitalz/go-test-retryer/test",
+			shellPath:          "/bin/bash",
+		},
+		retryerArgs:      "-exit-codes=flaky=10",
+		testCfg:          "flaky_test_failures_left: 1",
+		expectedExitCode: 10,
+		expectedCommands: []string{
+			"go test -v -count=1 -run=^TestFlaky$ github.com/zcapitalz/go-test-retryer/test",
+			"go test -v -count=1 -run=^TestFlaky$ github.com/zcapitalz/go-test-retryer/test",
+		},
+	},
+	{
+		name: "RetryBudgetExhaustedExitCode",
+		retryerCfg: Config{
+			testOutputTypeJSON: false,
+			maxRetriesPerTest:  2,
+			maxTotalRetries:    1,
+			testCommandName:    "go test",
+			testArgs:           "-v -count=1 -run=^TestFail$ github.com/zcapitalz/go-test-retryer/test",
+			shellPath:          "/bin/bash",
+		},
+		retryerArgs:      "-exit-codes=budget-exhausted=4",
+		expectedExitCode: 4,
+		expectedCommands: []string{
+			"go test -v -count=1 -run=^TestFail$ github.com/zcapitalz/go-test-retryer/test",
+			"go test -v -count=1 -run=^TestFail$ github.com/zcapitalz/go-test-retryer/test",
+		},
+	},
 }
@@ -0,0 +1,60 @@
+package retryer
+
+import (
+	"io"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// initialOutputStdin is the -initial-output value reading the output from
+// stdin.
+const initialOutputStdin = "-"
+
+// readInitialOutput updates the state with the output of an initial run
+// that already happened, e.g. "go test -json ./... | tee results.json",
+// instead of running tests. The output isn't printed again, it is only
+// saved to the raw JSON file.
+func (r *Retryer) readInitialOutput() error {
+	var input io.Reader = os.Stdin
+	if r.cfg.initialOutput != initialOutputStdin {
+		file, err := os.Open(r.cfg.initialOutput)
+		if err != nil {
+			return errors.Wrap(err, "open initial output")
+		}
+		defer file.Close()
+		input = file
+	}
+
+	r.round++
+	parser := newStreamParser(r.cfg.testOutputTypeJSON, r.cfg.outputTailLines)
+	stdoutWriter, stderrWriter := parser.writers()
+	defer stderrWriter.Close()
+	var stdout io.Writer = stdoutWriter
+	if r.rawJSONFile != nil {
+		stdout = io.MultiWriter(stdout, r.rawJSONFile)
+	}
+	if _, err := io.Copy(stdout, input); err != nil {
+		return errors.Wrap(err, "read initial output")
+	}
+	stdoutWriter.Close()
+
+	if format, mismatch := parser.formatMismatch(); mismatch {
+		if err := r.handleFormatMismatch(format); err != nil {
+			return err
+		}
+	}
+
+	report := parser.report()
+	r.updateStateWithTestReport(report)
+	// The exit code of the initial run is unknown, go test exits with 1 if
+	// tests fail.
+	if r.hasFailures() || len(buildFailuresFromReport(report)) > 0 {
+		r.lastTestExitCode = 1
+	}
+	if r.cfg.onBuildError == onBuildErrorAbort && len(buildFailuresFromReport(report)) > 0 {
+		r.logger.Warn("Build failed, aborting")
+		r.abortedOnBuildFailure = true
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+package retryer
+
+import (
+	"io"
+	"log/slog"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	logFormatText = "text"
+	logFormatJSON = "json"
+)
+
+// newLogger returns the logger for retryer diagnostics. Diagnostics are
+// written to stderr or to the log file, never to stdout, which carries test
+// output. The returned function closes the log file.
+func newLogger(cfg Config, stderr io.Writer) (*slog.Logger, func() error, error) {
+	w := stderr
+	closeLog := func() error { return nil }
+	if cfg.logFile != "" {
+		file, err := os.OpenFile(cfg.logFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "open log file")
+		}
+		w = file
+		closeLog = file.Close
+	}
+
+	opts := &slog.HandlerOptions{Level: cfg.logLevel}
+	if cfg.logFormat == logFormatJSON {
+		return slog.New(slog.NewJSONHandler(w, opts)), closeLog, nil
+	}
+	return slog.New(slog.NewTextHandler(w, opts)), closeLog, nil
+}
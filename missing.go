@@ -0,0 +1,180 @@
+package retryer
+
+import (
+	"bufio"
+	"bytes"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/jstemmer/go-junit-report/v2/gtr"
+	"github.com/pkg/errors"
+)
+
+var regexListedTest = regexp.MustCompile(`^(Test|Example|Fuzz)\w*$`)
+
+// listTests returns root tests that the original test arguments are expected
+// to run, as listed by go test -list with the same packages and -run filter.
+// Tests excluded by -skip are left out, since -list doesn't apply it.
+func (r *Retryer) listTests() ([]testID, error) {
+	listPattern := "."
+	if run, ok := r.testArgs.flagValue("run"); ok && run != "" {
+		listPattern = firstPatternElement(run)
+	}
+	var skip *regexp.Regexp
+	if skipPattern, ok := r.testArgs.flagValue("skip"); ok && skipPattern != "" {
+		var err error
+		if skip, err = regexp.Compile(firstPatternElement(skipPattern)); err != nil {
+			return nil, errors.Wrap(err, "parse -skip pattern")
+		}
+	}
+
+	listArgs := r.testArgs.
+		withoutFlags("run", "skip", "list", "json", "v", "failfast", "count", "shuffle", "bench", "fuzz",
+			"cover", "covermode", "coverpkg", "coverprofile", "cpuprofile", "memprofile", "blockprofile",
+			"mutexprofile", "trace", "outputdir").
+		withFlag("list", listPattern)
+
+	output := new(bytes.Buffer)
+	err := r.test(listArgs.String(), output, output)
+	if err != nil {
+		return nil, errors.Wrapf(err, "list tests: %v", strings.TrimSpace(output.String()))
+	}
+
+	var tests []testID
+	for _, test := range parseTestList(output.String()) {
+		if skip == nil || !skip.MatchString(test.name) {
+			tests = append(tests, test)
+		}
+	}
+	return tests, nil
+}
+
+// parseTestList parses go test -list output, where test names of a package
+// are followed by the package summary line.
+func parseTestList(output string) []testID {
+	var tests, pending []testID
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if regexListedTest.MatchString(line) {
+			pending = append(pending, testID{name: line})
+			continue
+		}
+		if matches := regexSummary.FindStringSubmatch(line); matches != nil {
+			for _, test := range pending {
+				test.pkg = matches[2]
+				tests = append(tests, test)
+			}
+			pending = nil
+		}
+	}
+	return tests
+}
+
+// firstPatternElement returns the part of a -run or -skip pattern matching
+// root tests: go test splits patterns by slashes outside of brackets and
+// parentheses and matches the parts against subtest levels.
+func firstPatternElement(pattern string) string {
+	depth := 0
+	for i := 0; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '[', '(':
+			depth++
+		case ']', ')':
+			if depth > 0 {
+				depth--
+			}
+		case '\\':
+			i++
+		case '/':
+			if depth == 0 {
+				return pattern[:i]
+			}
+		}
+	}
+	return pattern
+}
+
+// updateMissingTests records which of the tests expected to run in the last
+// round didn't report any result. Missing tests are run again in the next
+// round, without counting as retries, as long as every round makes progress
+// and runs at least one of them. Tests of packages that failed without failed
+// tests aren't missing: such packages fail the run or are retried as a whole.
+func (r *Retryer) updateMissingTests(expected []testID) {
+	if len(expected) == 0 {
+		return
+	}
+
+	var missing []testID
+	for _, test := range expected {
+		if _, ok := r.failedPackages[test.pkg]; ok {
+			continue
+		}
+		if _, ok := r.lastReportedTests[test]; ok {
+			if _, wasMissing := r.everMissingTests[test]; wasMissing {
+				delete(r.missingTests, test)
+				r.totalRecoveredMissingTests++
+			}
+			continue
+		}
+		missing = append(missing, test)
+		r.missingTests[test] = struct{}{}
+		r.everMissingTests[test] = struct{}{}
+	}
+
+	if len(missing) == len(expected) && len(r.lastMissingTests) > 0 {
+		r.logger.Warn("Tests still not run, giving up", "tests", missing)
+		r.lastMissingTests = nil
+		return
+	}
+	r.lastMissingTests = missing
+	if len(missing) > 0 {
+		r.logger.Info("Tests that didn't run", "tests", missing)
+	}
+}
+
+// selectMissingTestsToRun returns tests to run because they didn't run in
+// the last round.
+func (r *Retryer) selectMissingTestsToRun() []testID {
+	missing := r.lastMissingTests
+	r.totalMissingTestRuns += len(missing)
+	return missing
+}
+
+// reportedTests returns root tests of the report that reported a result,
+// regardless of the -cpu value they ran with.
+func reportedTests(report gtr.Report) map[testID]struct{} {
+	tests := make(map[testID]struct{})
+	for _, pkg := range report.Packages {
+		for _, test := range filter(pkg.Tests, isRootTest) {
+			tests[testID{pkg: pkg.Name, name: test.Name}] = struct{}{}
+		}
+	}
+	return tests
+}
+
+func (r *Retryer) logMissingTests() {
+	if len(r.everMissingTests) == 0 {
+		return
+	}
+
+	r.logger.Info("Tests that didn't run",
+		"tests", len(r.everMissingTests),
+		"runs", r.totalMissingTestRuns,
+		"recovered", r.totalRecoveredMissingTests)
+	if len(r.missingTests) > 0 {
+		r.logger.Info("Tests never run", "tests", sortedTestIDs(r.missingTests))
+	}
+}
+
+func sortedTestIDs(tests map[testID]struct{}) []testID {
+	sorted := make([]testID, 0, len(tests))
+	for test := range tests {
+		sorted = append(sorted, test)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].String() < sorted[j].String()
+	})
+	return sorted
+}
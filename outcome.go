@@ -0,0 +1,166 @@
+package retryer
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// outcome is the overall result of a retryer run, mapped to the exit code
+// by the exit code policy.
+type outcome string
+
+const (
+	outcomePassed          outcome = "passed"
+	outcomeFlaky           outcome = "flaky"
+	outcomeFailed          outcome = "failed"
+	outcomeBudgetExhausted outcome = "budget-exhausted"
+	outcomeTooManyFailures outcome = "too-many-failures"
+	outcomeBuildFailed     outcome = "build-failed"
+	outcomeVetFailed       outcome = "vet-failed"
+	outcomeInternalError   outcome = "internal-error"
+)
+
+var outcomes = []outcome{
+	outcomePassed,
+	outcomeFlaky,
+	outcomeFailed,
+	outcomeBudgetExhausted,
+	outcomeTooManyFailures,
+	outcomeBuildFailed,
+	outcomeVetFailed,
+	outcomeInternalError,
+}
+
+const exitCodeLast = "last"
+
+// exitCode is either a fixed exit code or the exit code of the last failed
+// test run.
+type exitCode struct {
+	code int
+	last bool
+}
+
+func (c exitCode) String() string {
+	if c.last {
+		return exitCodeLast
+	}
+	return strconv.Itoa(c.code)
+}
+
+// exitCodes maps outcomes to exit codes. It implements flag.Value and is set
+// from "outcome=code,..." strings, where code is a number or "last".
+type exitCodes map[outcome]exitCode
+
+func defaultExitCodes() exitCodes {
+	return exitCodes{
+		outcomePassed:          {code: 0},
+		outcomeFlaky:           {code: 0},
+		outcomeFailed:          {last: true},
+		outcomeBudgetExhausted: {last: true},
+		outcomeTooManyFailures: {last: true},
+		outcomeBuildFailed:     {code: 1},
+		outcomeVetFailed:       {code: 3},
+		outcomeInternalError:   {code: 1},
+	}
+}
+
+func (v exitCodes) String() string {
+	parts := make([]string, 0, len(v))
+	for _, o := range outcomes {
+		if code, ok := v[o]; ok {
+			parts = append(parts, fmt.Sprintf("%v=%v", o, code))
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+func (v exitCodes) Set(s string) error {
+	for _, part := range strings.Split(s, ",") {
+		if part == "" {
+			continue
+		}
+		outcomeStr, codeStr, ok := strings.Cut(part, "=")
+		if !ok {
+			return fmt.Errorf("expected outcome=code, got %q", part)
+		}
+		o, err := parseOutcome(outcomeStr)
+		if err != nil {
+			return err
+		}
+		if codeStr == exitCodeLast {
+			v[o] = exitCode{last: true}
+			continue
+		}
+		code, err := strconv.Atoi(codeStr)
+		if err != nil || code < 0 || code > 255 {
+			return fmt.Errorf("invalid exit code for %v: %q", o, codeStr)
+		}
+		v[o] = exitCode{code: code}
+	}
+	return nil
+}
+
+// exitCode returns the exit code for the outcome. The last test exit code is
+// used for "last" mappings; 1 is used instead if no test run failed.
+func (v exitCodes) exitCode(o outcome, lastTestExitCode int) int {
+	code, ok := v[o]
+	if !ok {
+		code = defaultExitCodes()[o]
+	}
+	if !code.last {
+		return code.code
+	}
+	if lastTestExitCode <= 0 {
+		return 1
+	}
+	return lastTestExitCode
+}
+
+func parseOutcome(s string) (outcome, error) {
+	for _, o := range outcomes {
+		if string(o) == s {
+			return o, nil
+		}
+	}
+	return "", fmt.Errorf("unknown outcome %q", s)
+}
+
+// outcome returns the outcome of the run. Failures left after retries take
+// precedence over build failures, which take precedence over flakiness.
+func (r *Retryer) outcome() outcome {
+	switch {
+	case r.abortedOnBuildFailure:
+		return r.buildFailureOutcome()
+	case r.tooManyFailures:
+		return outcomeTooManyFailures
+	case len(r.testsWithVerdict(verdictFailed)) > 0,
+		len(r.failedPackages) > 0,
+		len(r.missingTests) > 0,
+		r.unexplainedFailure:
+		if r.retryBudgetExhausted {
+			return outcomeBudgetExhausted
+		}
+		return outcomeFailed
+	case len(r.buildFailures) > 0:
+		return r.buildFailureOutcome()
+	case len(r.testsWithVerdict(verdictFlaky)) > 0, len(r.everFailedPackages) > 0:
+		if r.cfg.strict {
+			return outcomeFailed
+		}
+		return outcomeFlaky
+	default:
+		return outcomePassed
+	}
+}
+
+// buildFailureOutcome returns outcomeBuildFailed if any package failed to
+// compile and outcomeVetFailed if only go vet checks failed.
+func (r *Retryer) buildFailureOutcome() outcome {
+	for _, failure := range r.buildFailures {
+		if !failure.vet {
+			return outcomeBuildFailed
+		}
+	}
+	return outcomeVetFailed
+}
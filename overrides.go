@@ -0,0 +1,286 @@
+package retryer
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// overrideRule changes retries of tests matching its package pattern and
+// test name regular expression. Unset fields keep the values of earlier
+// rules or of the global options.
+type overrideRule struct {
+	Package string        `yaml:"package,omitempty"`
+	Test    string        `yaml:"test,omitempty"`
+	Retries *int          `yaml:"retries,omitempty"`
+	Timeout time.Duration `yaml:"timeout,omitempty"`
+	Retry   *bool         `yaml:"retry,omitempty"`
+
+	packageRegex *regexp.Regexp
+	testRegex    *regexp.Regexp
+}
+
+// overrideRules implements flag.Value. Every value is a YAML rule or list of
+// rules that is appended to the rules set before, so rules of a profile
+// follow rules of the top level of the config file.
+type overrideRules []*overrideRule
+
+// testOverride is the combined effect of the rules matching a test.
+type testOverride struct {
+	retries *int
+	timeout time.Duration
+	rules   []string
+}
+
+func (v *overrideRules) String() string {
+	if v == nil {
+		return ""
+	}
+	parts := make([]string, 0, len(*v))
+	for _, rule := range *v {
+		parts = append(parts, rule.String())
+	}
+	return strings.Join(parts, ", ")
+}
+
+func (v *overrideRules) Set(s string) error {
+	var value any
+	if err := yaml.Unmarshal([]byte(s), &value); err != nil {
+		return err
+	}
+	if _, ok := value.(map[string]any); ok {
+		value = []any{value}
+	}
+	data, err := yaml.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	var rules []*overrideRule
+	decoder := yaml.NewDecoder(strings.NewReader(string(data)))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&rules); err != nil {
+		return err
+	}
+	for _, rule := range rules {
+		if rule.Retries != nil && *rule.Retries < 0 {
+			return fmt.Errorf("retries of rule %v should be non-negative", rule)
+		}
+		rule.packageRegex = packagePatternRegex(rule.Package, "")
+		if rule.Test != "" {
+			if rule.testRegex, err = regexp.Compile(rule.Test); err != nil {
+				return err
+			}
+		}
+	}
+	*v = append(*v, rules...)
+	return nil
+}
+
+func (v *overrideRules) yamlValue() {}
+
+// resolve compiles package patterns of the rules with relative patterns
+// resolved against dirImportPath, the import path of the directory go test
+// runs in.
+func (v overrideRules) resolve(dirImportPath string) {
+	for _, rule := range v {
+		rule.packageRegex = packagePatternRegex(rule.Package, dirImportPath)
+	}
+}
+
+// hasRelativePatterns reports whether any rule has a package pattern
+// relative to the directory go test runs in.
+func (v overrideRules) hasRelativePatterns() bool {
+	for _, rule := range v {
+		if isRelativePattern(rule.Package) {
+			return true
+		}
+	}
+	return false
+}
+
+// match combines the rules matching the test, later rules overriding fields
+// set by earlier ones. An empty test name matches the package as a whole,
+// which only rules without a test pattern do.
+func (v overrideRules) match(pkg, test string) testOverride {
+	var o testOverride
+	for _, rule := range v {
+		if !rule.packageRegex.MatchString(pkg) {
+			continue
+		}
+		if rule.testRegex != nil && (test == "" || !rule.testRegex.MatchString(test)) {
+			continue
+		}
+		if rule.Retries != nil {
+			o.retries = rule.Retries
+		}
+		if rule.Retry != nil && !*rule.Retry {
+			zero := 0
+			o.retries = &zero
+		}
+		if rule.Timeout != 0 {
+			o.timeout = rule.Timeout
+		}
+		o.rules = append(o.rules, rule.String())
+	}
+	return o
+}
+
+func (rule *overrideRule) String() string {
+	var parts []string
+	if rule.Package != "" {
+		parts = append(parts, "package="+rule.Package)
+	}
+	if rule.Test != "" {
+		parts = append(parts, "test="+rule.Test)
+	}
+	if rule.Retries != nil {
+		parts = append(parts, fmt.Sprintf("retries=%v", *rule.Retries))
+	}
+	if rule.Timeout != 0 {
+		parts = append(parts, "timeout="+rule.Timeout.String())
+	}
+	if rule.Retry != nil {
+		parts = append(parts, fmt.Sprintf("retry=%v", *rule.Retry))
+	}
+	return "{" + strings.Join(parts, " ") + "}"
+}
+
+// packagePatternRegex converts a package pattern to a regular expression
+// matching import paths. As in go test, "..." matches any string; "*" matches
+// any string within a path element. Patterns starting with "./" or "../" are
+// resolved against dirImportPath and match import paths from the start, so
+// with dirImportPath "example.com/module", "./integration/..." matches
+// "example.com/module/integration/db" but not
+// "example.com/module/pkg/integration". Other patterns match the whole
+// import path or its trailing path elements, as do relative patterns if
+// dirImportPath is unknown. An empty pattern matches any package.
+func packagePatternRegex(pattern, dirImportPath string) *regexp.Regexp {
+	anchor := `(^|/)`
+	if isRelativePattern(pattern) {
+		if dirImportPath != "" {
+			pattern = path.Join(dirImportPath, pattern)
+			anchor = `^`
+		} else {
+			pattern = strings.TrimPrefix(pattern, "./")
+		}
+	}
+	if pattern == "" || pattern == "..." || pattern == "." {
+		return regexp.MustCompile("")
+	}
+	quoted := regexp.QuoteMeta(pattern)
+	quoted = strings.ReplaceAll(quoted, `\.\.\.`, `.*`)
+	quoted = strings.ReplaceAll(quoted, `\*`, `[^/]*`)
+	// "pkg/..." matches "pkg" itself too.
+	quoted = strings.ReplaceAll(quoted, `/.*`, `(/.*)?`)
+	return regexp.MustCompile(anchor + quoted + `$`)
+}
+
+func isRelativePattern(pattern string) bool {
+	return pattern == "." || strings.HasPrefix(pattern, "./") || strings.HasPrefix(pattern, "../")
+}
+
+// dirImportPath returns the import path of the directory go test runs in,
+// the working directory or the -C directory of the test arguments, from the
+// path and directory of its module as go list -m reports them.
+func (r *Retryer) dirImportPath() (string, error) {
+	dir, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+	if c, ok := r.testArgs.flagValue("C"); ok {
+		dir = filepath.Join(dir, c)
+	}
+	listArgs := append([]string{"list"}, testBuildArgs(r.testArgs).listFlags...)
+	output, err := goCommand(append(listArgs, "-m", "-f", "{{.Path}} {{.Dir}}")...)
+	if err != nil {
+		return "", err
+	}
+	// A workspace has several modules, the directory is in one of them.
+	for _, line := range strings.Split(strings.TrimSpace(output), "\n") {
+		modulePath, moduleDir, _ := strings.Cut(line, " ")
+		rel, err := filepath.Rel(moduleDir, dir)
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, "../") {
+			return path.Join(modulePath, filepath.ToSlash(rel)), nil
+		}
+	}
+	return "", fmt.Errorf("directory %v isn't in a module", dir)
+}
+
+// maxRetriesForTest returns the maximum retries of a test, or of a package
+// retried as a whole if the test name is empty. Override rules take
+// precedence over retry directives, which take precedence over the per-test
+// limit.
+func (r *Retryer) maxRetriesForTest(test testID) int {
+	maxRetries := r.cfg.maxRetriesPerTest
+	if d, ok := r.directive(test); ok {
+		maxRetries = d.retries
+	}
+	if retries := r.cfg.overrides.match(test.pkg, test.name).retries; retries != nil {
+		maxRetries = *retries
+	}
+	return maxRetries
+}
+
+// maxRetriesForFailureKind returns the maximum retries of a test whose last
+// failure was of the given kind. Failure kind limits can only lower the limit
+// of the test.
+func (r *Retryer) maxRetriesForFailureKind(test testID, kind failureKind) int {
+	maxRetries := r.maxRetriesForTest(test)
+	if retries, ok := r.cfg.failureKindRetries[kind]; ok && retries < maxRetries {
+		return retries
+	}
+	return maxRetries
+}
+
+// recordOverride remembers and logs the retry directive and override rules
+// applied to a failed test, or to a package retried as a whole if the test
+// name is empty.
+func (r *Retryer) recordOverride(test testID) {
+	if _, ok := r.overriddenTests[test]; ok {
+		return
+	}
+	var rules []string
+	if d, ok := r.directive(test); ok {
+		rules = append(rules, d.String())
+	}
+	rules = append(rules, r.cfg.overrides.match(test.pkg, test.name).rules...)
+	if len(rules) == 0 {
+		return
+	}
+	r.overriddenTests[test] = rules
+	r.logger.Debug("Override rules applied", "test", test, "rules", rules)
+}
+
+// retryTimeout returns the longest timeout that override rules set for the
+// tests, or for the package retried as a whole if there are no tests.
+func (r *Retryer) retryTimeout(pkg string, tests []string) time.Duration {
+	if len(tests) == 0 {
+		return r.cfg.overrides.match(pkg, "").timeout
+	}
+	var timeout time.Duration
+	for _, test := range tests {
+		timeout = max(timeout, r.cfg.overrides.match(pkg, test).timeout)
+	}
+	return timeout
+}
+
+// withRetryTimeout returns the test arguments of a retry of the tests of the
+// package with -timeout dropped and the retry timeout of override rules
+// appended as --test.timeout. Arguments are unchanged if no rule sets one.
+func (r *Retryer) withRetryTimeout(testArgs, pkg string, tests []string) string {
+	timeout := r.retryTimeout(pkg, tests)
+	if timeout == 0 {
+		return testArgs
+	}
+	if args, err := parseTestArgs(testArgs); err == nil {
+		testArgs = args.withoutFlags("timeout").String()
+	}
+	return testArgs + ` "--test.timeout=` + timeout.String() + `"`
+}
@@ -0,0 +1,76 @@
+package retryer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPackagePatternRegex(t *testing.T) {
+	testCases := []struct {
+		pattern  string
+		dir      string
+		pkg      string
+		expected bool
+	}{
+		{pattern: "", pkg: "example.com/m/pkg", expected: true},
+		{pattern: "./...", pkg: "example.com/m/pkg", expected: true},
+		{pattern: "./integration/...", pkg: "example.com/m/integration", expected: true},
+		{pattern: "./integration/...", pkg: "example.com/m/integration/db", expected: true},
+		{pattern: "./integration/...", pkg: "example.com/m/pkg/integrations", expected: false},
+		{pattern: "pkg/*", pkg: "example.com/m/pkg/a", expected: true},
+		{pattern: "pkg/*", pkg: "example.com/m/pkg/a/b", expected: false},
+		{pattern: "example.com/m/pkg", pkg: "example.com/m/pkg", expected: true},
+		{pattern: "m/pkg", pkg: "example.com/mm/pkg", expected: false},
+		{pattern: "./pkg/...", dir: "example.com/m", pkg: "example.com/m/pkg/db", expected: true},
+		{pattern: "./pkg/...", dir: "example.com/m", pkg: "example.com/m/integration/pkg/db", expected: false},
+		{pattern: "./test", dir: "example.com/m", pkg: "example.com/m/other/test", expected: false},
+		{pattern: "./...", dir: "example.com/m/sub", pkg: "example.com/m/other", expected: false},
+		{pattern: "../other", dir: "example.com/m/sub", pkg: "example.com/m/other", expected: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.pattern+" "+tc.pkg, func(t *testing.T) {
+			assert.Equal(t, tc.expected, packagePatternRegex(tc.pattern, tc.dir).MatchString(tc.pkg))
+		})
+	}
+}
+
+func TestOverrideRulesMatch(t *testing.T) {
+	var rules overrideRules
+	require.NoError(t, rules.Set(`[{package: ./integration/..., retries: 3, timeout: 5m}, {package: ./pkg/..., retry: false}]`))
+	require.NoError(t, rules.Set(`{package: ./integration/..., test: ^TestSlow, timeout: 10m}`))
+	rules.resolve("example.com/m")
+
+	o := rules.match("example.com/m/integration/db", "TestSlowQuery")
+	require.NotNil(t, o.retries)
+	assert.Equal(t, 3, *o.retries)
+	assert.Equal(t, 10*time.Minute, o.timeout)
+	assert.Equal(t, []string{"{package=./integration/... retries=3 timeout=5m0s}", "{package=./integration/... test=^TestSlow timeout=10m0s}"}, o.rules)
+
+	o = rules.match("example.com/m/integration/db", "")
+	assert.Equal(t, 5*time.Minute, o.timeout)
+
+	o = rules.match("example.com/m/pkg/a", "TestA")
+	require.NotNil(t, o.retries)
+	assert.Equal(t, 0, *o.retries)
+
+	// The nested package is in ./integration, not in ./pkg.
+	o = rules.match("example.com/m/integration/pkg/db", "TestA")
+	require.NotNil(t, o.retries)
+	assert.Equal(t, 3, *o.retries)
+
+	o = rules.match("example.com/m/cmd", "TestA")
+	assert.Nil(t, o.retries)
+	assert.Empty(t, o.rules)
+}
+
+func TestOverrideRulesSetInvalid(t *testing.T) {
+	var rules overrideRules
+	assert.Error(t, rules.Set(`{package: ./..., retires: 3}`))
+	assert.Error(t, rules.Set(`{test: "("}`))
+	assert.Error(t, rules.Set(`{retries: -1}`))
+	assert.Empty(t, rules)
+}
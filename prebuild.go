@@ -0,0 +1,227 @@
+package retryer
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// goTestBuildFlags are go test flags that affect building of test binaries
+// rather than running tests.
+var goTestBuildFlags = map[string]struct{}{
+	"C": {}, "a": {}, "x": {}, "p": {}, "race": {}, "msan": {}, "asan": {},
+	"cover": {}, "covermode": {}, "coverpkg": {}, "asmflags": {},
+	"buildmode": {}, "buildvcs": {}, "compiler": {}, "gccgoflags": {},
+	"gcflags": {}, "installsuffix": {}, "ldflags": {}, "linkshared": {},
+	"mod": {}, "modcacherw": {}, "modfile": {}, "overlay": {}, "pgo": {},
+	"pkgdir": {}, "tags": {}, "trimpath": {}, "toolexec": {}, "work": {},
+}
+
+// goTestOnlyFlags are go test flags that neither affect the build nor are
+// passed to test binaries.
+var goTestOnlyFlags = map[string]struct{}{
+	"c": {}, "o": {}, "i": {}, "n": {}, "json": {}, "exec": {}, "vet": {},
+}
+
+// defaultTestTimeout is the -timeout go test passes to test binaries if it
+// isn't set.
+const defaultTestTimeout = "10m"
+
+// testBinaries builds test binaries of packages with go test -c once and
+// returns commands running them directly, so retries don't relink binaries
+// and rerun go vet every round.
+type testBinaries struct {
+	dir      string
+	json     bool
+	binaries map[string]*testBinary
+}
+
+// testBinary is a test binary of a package. A nil binary means that it
+// couldn't be built and go test is used instead.
+type testBinary struct {
+	path string
+	dir  string
+}
+
+func newTestBinaries(json bool) (*testBinaries, error) {
+	dir, err := os.MkdirTemp("", "go-test-retryer-bin-*")
+	if err != nil {
+		return nil, errors.Wrap(err, "create test binaries directory")
+	}
+	return &testBinaries{dir: dir, json: json, binaries: make(map[string]*testBinary)}, nil
+}
+
+func (b *testBinaries) remove() {
+	os.RemoveAll(b.dir)
+}
+
+// testCommand returns the shell command running the tests of the test
+// arguments: the test command, or a prebuilt binary in retries with -prebuild.
+func (r *Retryer) testCommand(testArgs string) (string, error) {
+	if r.testBinaries != nil && !r.firstRun {
+		prebuiltCommand, ok, err := r.prebuiltCommand(testArgs)
+		if err != nil {
+			return "", err
+		}
+		if ok {
+			return prebuiltCommand, nil
+		}
+	}
+	return r.cfg.testCommandName + " " + testArgs, nil
+}
+
+// prebuiltCommand returns a shell command running the tests of the test
+// arguments with a prebuilt binary. ok is false if the arguments don't name
+// exactly one package or its binary couldn't be built, then go test should be
+// used.
+func (r *Retryer) prebuiltCommand(testArgs string) (command string, ok bool, err error) {
+	args, err := parseTestArgs(testArgs)
+	if err != nil {
+		return "", false, err
+	}
+	if len(args.packages) != 1 {
+		return "", false, nil
+	}
+	var pkg string
+	for i := range args.packages {
+		pkg = args.args[i].value
+	}
+
+	binary, err := r.testBinaries.binary(r, args, pkg)
+	if err != nil || binary == nil {
+		return "", false, err
+	}
+	return r.testBinaries.command(args, pkg, binary), true, nil
+}
+
+// binary returns the test binary of the package, building it on first use.
+func (b *testBinaries) binary(r *Retryer, args testArgs, pkg string) (*testBinary, error) {
+	if binary, ok := b.binaries[pkg]; ok {
+		return binary, nil
+	}
+	b.binaries[pkg] = nil
+
+	buildArgs := testBuildArgs(args)
+	dir, err := goCommand(append(append([]string{"list"}, buildArgs.listFlags...), "-f", "{{.Dir}}", pkg)...)
+	if err != nil {
+		r.logger.Warn("Couldn't find package directory, retrying with go test", "package", pkg, "error", err)
+		return nil, nil
+	}
+
+	path := filepath.Join(b.dir, strings.NewReplacer("/", "_", ".", "_").Replace(pkg)+".test")
+	buildCommand := append([]string{"test", "-c", "-o", path}, buildArgs.buildFlags...)
+	r.logger.Debug("Building test binary", "package", pkg, "command", "go "+strings.Join(append(buildCommand, pkg), " "))
+	if _, err := goCommand(append(buildCommand, pkg)...); err != nil {
+		r.logger.Warn("Couldn't build test binary, retrying with go test", "package", pkg, "error", err)
+		return nil, nil
+	}
+	// go test -c doesn't write a binary for packages without test files.
+	if _, err := os.Stat(path); err != nil {
+		return nil, nil
+	}
+
+	binary := &testBinary{path: path, dir: strings.TrimSpace(dir)}
+	b.binaries[pkg] = binary
+	return binary, nil
+}
+
+type binaryBuildArgs struct {
+	buildFlags []string
+	listFlags  []string
+}
+
+// testBuildArgs returns go test -c flags building the binary the way go test
+// would build it for the test arguments, and the go list flags finding its
+// package the same way.
+func testBuildArgs(args testArgs) binaryBuildArgs {
+	var buildArgs binaryBuildArgs
+	coverprofile := false
+	forEachFlagValue(args, func(name, word string) {
+		if name == "coverprofile" {
+			coverprofile = true
+		}
+		if _, ok := goTestBuildFlags[name]; !ok {
+			return
+		}
+		switch name {
+		case "C":
+			// -C must be the first flag of go commands.
+			buildArgs.buildFlags = append([]string{word}, buildArgs.buildFlags...)
+			buildArgs.listFlags = append([]string{word}, buildArgs.listFlags...)
+		case "mod", "modfile", "tags", "overlay", "work":
+			buildArgs.buildFlags = append(buildArgs.buildFlags, word)
+			buildArgs.listFlags = append(buildArgs.listFlags, word)
+		default:
+			buildArgs.buildFlags = append(buildArgs.buildFlags, word)
+		}
+	})
+	if coverprofile && !args.hasFlag("cover") {
+		buildArgs.buildFlags = append(buildArgs.buildFlags, "-cover")
+	}
+	return buildArgs
+}
+
+// command returns the shell command running the binary in the package
+// directory with test flags of the test arguments, followed by the package
+// summary line go test would print. In json mode the output is converted by
+// test2json.
+func (b *testBinaries) command(args testArgs, pkg string, binary *testBinary) string {
+	var testFlags []string
+	if b.json {
+		testFlags = append(testFlags, "-test.v=test2json")
+	}
+	testFlags = append(testFlags, "-test.paniconexit0")
+	if !args.hasFlag("timeout") {
+		testFlags = append(testFlags, "-test.timeout="+defaultTestTimeout)
+	}
+	forEachFlagValue(args, func(name, word string) {
+		_, build := goTestBuildFlags[name]
+		_, goTestOnly := goTestOnlyFlags[name]
+		if build || goTestOnly || (b.json && name == "v") {
+			return
+		}
+		testFlags = append(testFlags, shellQuote("-test."+strings.TrimPrefix(word, "-")))
+	})
+
+	run := strings.Join(append(append([]string{shellQuote(binary.path)}, testFlags...), args.binaryArgs()...), " ")
+	script := fmt.Sprintf(`%v; status=$?; if [ $status -eq 0 ]; then printf 'ok  \t%%s\n' %v; else printf 'FAIL\t%%s\n' %v; fi; exit $status`,
+		run, shellQuote(pkg), shellQuote(pkg))
+	if b.json {
+		return fmt.Sprintf("cd %v && go tool test2json -t -p %v /bin/sh -c %v", shellQuote(binary.dir), shellQuote(pkg), shellQuote(script))
+	}
+	return fmt.Sprintf("cd %v && %v", shellQuote(binary.dir), script)
+}
+
+// forEachFlagValue calls f for every known go test flag of the arguments
+// with the flag written as a single "-name=value" or "-name" word.
+func forEachFlagValue(args testArgs, f func(name, word string)) {
+	args.forEachFlag(func(i int, name string, hasValue bool) {
+		_, known := goTestValueFlags[name]
+		_, isBool := goTestBoolFlags[name]
+		if !known && !isBool {
+			return
+		}
+		word := args.args[i].value
+		if known && !hasValue && i+1 < len(args.args) {
+			word = "-" + name + "=" + args.args[i+1].value
+		}
+		f(name, "-"+strings.TrimPrefix(strings.TrimLeft(word, "-"), "test."))
+	})
+}
+
+func goCommand(args ...string) (string, error) {
+	stdout := new(bytes.Buffer)
+	stderr := new(bytes.Buffer)
+	command := exec.Command("go", args...)
+	command.Stdout = stdout
+	command.Stderr = stderr
+	if err := command.Run(); err != nil {
+		return "", errors.Wrap(err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), nil
+}
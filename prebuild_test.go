@@ -0,0 +1,31 @@
+package retryer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTestBuildArgs(t *testing.T) {
+	args, err := parseTestArgs("-v -race -count=1 -tags integration -coverprofile=c.out ./pkg -config-path=x.yaml")
+	require.NoError(t, err)
+
+	buildArgs := testBuildArgs(args)
+	assert.Equal(t, []string{"-race", "-tags=integration", "-cover"}, buildArgs.buildFlags)
+	assert.Equal(t, []string{"-tags=integration"}, buildArgs.listFlags)
+}
+
+func TestTestBinariesCommand(t *testing.T) {
+	args, err := parseTestArgs(`-v -race -count=1 -json "-run=^(TestA)$" ./pkg -config-path=x.yaml -args -flag`)
+	require.NoError(t, err)
+	binary := &testBinary{path: "/bin/pkg.test", dir: "/src/pkg"}
+
+	assert.Equal(t,
+		`cd '/src/pkg' && '/bin/pkg.test' -test.paniconexit0 -test.timeout=10m '-test.v' '-test.count=1' '-test.run=^(TestA)$' -config-path=x.yaml -flag; `+
+			`status=$?; if [ $status -eq 0 ]; then printf 'ok  \t%s\n' 'pkg'; else printf 'FAIL\t%s\n' 'pkg'; fi; exit $status`,
+		(&testBinaries{}).command(args, "pkg", binary))
+	assert.Contains(t,
+		(&testBinaries{json: true}).command(args, "pkg", binary),
+		`cd '/src/pkg' && go tool test2json -t -p 'pkg' /bin/sh -c `)
+}
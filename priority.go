@@ -0,0 +1,98 @@
+package retryer
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Strategies ordering failed tests for the total retries budget.
+const (
+	priorityReport         = "report"
+	priorityCheapest       = "cheapest"
+	priorityFlakiest       = "flakiest"
+	priorityFewestAttempts = "fewest-attempts"
+)
+
+var priorities = []string{priorityReport, priorityCheapest, priorityFlakiest, priorityFewestAttempts}
+
+// testHistory is the flakiness of tests in earlier runs by test name as the
+// retryer logs it, e.g. "example.com/m/pkg.TestDB": 0.25. Higher values mean
+// flakier tests, so the file can hold flaky rates or flaky run counts.
+type testHistory map[string]float64
+
+func loadTestHistory(path string) (testHistory, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, InvalidParameterError{fmt.Sprintf("Couldn't read history file: %v", err)}
+	}
+	history := make(testHistory)
+	if err := yaml.Unmarshal(data, &history); err != nil {
+		return nil, InvalidParameterError{fmt.Sprintf("Couldn't parse history file %v: %v", path, err)}
+	}
+	return history, nil
+}
+
+// flakiness returns the flakiness of the test in the history. Identities
+// with -cpu values share the flakiness of the test.
+func (h testHistory) flakiness(test testID) float64 {
+	return h[testID{pkg: test.pkg, name: test.name}.String()]
+}
+
+// prioritizedFailedTests returns the failed tests of the last round in the
+// order they get the total retries budget in: report order, cheapest first
+// by duration of the last attempt, flakiest first by history or fewest
+// attempts first. Ties keep the report order. The order is logged, so that
+// it's clear why a test wasn't retried when the budget ran out.
+func (r *Retryer) prioritizedFailedTests() []testID {
+	tests := append([]testID(nil), r.lastFailedTests...)
+	if len(tests) < 2 {
+		return tests
+	}
+
+	var less func(a, b testID) bool
+	var describe func(test testID) string
+	switch r.cfg.retryPriority {
+	case priorityCheapest:
+		less = func(a, b testID) bool { return r.lastDuration(a) < r.lastDuration(b) }
+		describe = func(test testID) string { return fmt.Sprintf("%v (%v)", test, r.lastDuration(test)) }
+	case priorityFlakiest:
+		less = func(a, b testID) bool { return r.history.flakiness(a) > r.history.flakiness(b) }
+		describe = func(test testID) string { return fmt.Sprintf("%v (flakiness %v)", test, r.history.flakiness(test)) }
+	case priorityFewestAttempts:
+		less = func(a, b testID) bool { return r.attempts(a) < r.attempts(b) }
+		describe = func(test testID) string { return fmt.Sprintf("%v (%v attempts)", test, r.attempts(test)) }
+	default:
+		return tests
+	}
+	sort.SliceStable(tests, func(i, j int) bool { return less(tests[i], tests[j]) })
+
+	order := make([]string, 0, len(tests))
+	for _, test := range tests {
+		order = append(order, describe(test))
+	}
+	r.logger.Info("Retry priority", "strategy", r.cfg.retryPriority, "order", order)
+	return tests
+}
+
+// lastDuration returns the duration of a run of the test in its last
+// attempt, on average if the attempt had several runs.
+func (r *Retryer) lastDuration(test testID) time.Duration {
+	rec, ok := r.testRecords[test]
+	if !ok || len(rec.attempts) == 0 {
+		return 0
+	}
+	a := rec.attempts[len(rec.attempts)-1]
+	return a.duration / time.Duration(max(len(a.votes), 1))
+}
+
+// attempts returns the number of attempts of the test so far.
+func (r *Retryer) attempts(test testID) int {
+	if rec, ok := r.testRecords[test]; ok {
+		return len(rec.attempts)
+	}
+	return 0
+}
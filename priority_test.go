@@ -0,0 +1,68 @@
+package retryer
+
+import (
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrioritizedFailedTests(t *testing.T) {
+	a := testID{pkg: "pkg", name: "TestA"}
+	b := testID{pkg: "pkg", name: "TestB"}
+	c := testID{pkg: "other", name: "TestC"}
+	newRetryer := func(priority string) *Retryer {
+		return &Retryer{
+			cfg:             Config{retryPriority: priority},
+			logger:          slog.New(slog.NewTextHandler(io.Discard, nil)),
+			lastFailedTests: []testID{a, b, c},
+			testRecords: map[testID]*testRecord{
+				a: {attempts: []attempt{{failed: 1, duration: 3 * time.Second}}},
+				b: {attempts: []attempt{{failed: 1}, {failed: 1, duration: time.Second}}},
+				c: {attempts: []attempt{{failed: 1, duration: 2 * time.Second}}},
+			},
+			history: testHistory{"pkg.TestB": 0.5, "other.TestC": 0.1},
+		}
+	}
+
+	testCases := []struct {
+		priority string
+		expected []testID
+	}{
+		{priority: priorityReport, expected: []testID{a, b, c}},
+		{priority: priorityCheapest, expected: []testID{b, c, a}},
+		{priority: priorityFlakiest, expected: []testID{b, c, a}},
+		{priority: priorityFewestAttempts, expected: []testID{a, c, b}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.priority, func(t *testing.T) {
+			assert.Equal(t, tc.expected, newRetryer(tc.priority).prioritizedFailedTests())
+		})
+	}
+}
+
+func TestCheapestRetryPriorityGetsBudget(t *testing.T) {
+	plan, _ := dryRun(t, Config{
+		maxRetriesPerTest: 1,
+		maxTotalRetries:   1,
+		retryPriority:     priorityCheapest,
+	}, "=== RUN   TestSlow\n"+
+		"--- FAIL: TestSlow (5.00s)\n"+
+		"=== RUN   TestFast\n"+
+		"--- FAIL: TestFast (0.01s)\n"+
+		"FAIL\n"+
+		"FAIL\tpkg\t5.01s\n")
+
+	assert.Equal(t, `Retry round 1:
+  package pkg
+    TestFast (fail): retry 1/1
+Not retried:
+  pkg.TestSlow (fail): total retries exhausted
+Commands:
+  go test -v pkg "--test.run=^((TestFast))$"
+Total retries: 1 planned, 0 of 1 left after the round
+`, plan)
+}
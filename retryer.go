@@ -3,183 +3,718 @@ package retryer
 import (
 	"fmt"
 	"io"
+	"log/slog"
+	"os"
 	"os/exec"
+	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/jstemmer/go-junit-report/v2/gtr"
-	"github.com/jstemmer/go-junit-report/v2/parser/gotest"
 	"github.com/pkg/errors"
 )
 
 type Retryer struct {
-	cfg                    Config
-	stdout                 io.Writer
-	stderr                 io.Writer
-	totalRetriesLeft       int
-	totalSuccessfulRetries int
-	totalRetriesPerTest    map[string]int
-	everFailedTests        map[string]struct{}
-	lastFailedTests        []string
-	lastTestExitCode       int
-	firstRun               bool
-	failedAnyPackageBuild  bool
+	cfg                     Config
+	testArgs                testArgs
+	stdout                  io.Writer
+	stderr                  io.Writer
+	logger                  *slog.Logger
+	renderer                *consoleRenderer
+	rawJSONFile             *os.File
+	coverageProfiles        *coverageProfiles
+	testBinaries            *testBinaries
+	totalRetriesLeft        int
+	totalSuccessfulRetries  int
+	totalRetriesPerTest     map[testID]int
+	repetitions             testRepetitions
+	testRecords             map[testID]*testRecord
+	lastFailedTests         []testID
+	lastRetriedTests        map[testID]struct{}
+	lastFailureKinds        map[testID]failureKind
+	failureKindCounts       map[failureKind]int
+	testsNotRetriedByKind   map[testID]failureKind
+	overriddenTests         map[testID][]string
+	lastFingerprints        map[testID]string
+	identicalFailures       map[testID]int
+	deterministicFailures   map[testID]string
+	failureCauses           map[testID]string
+	history                 testHistory
+	retryTimeLeft           time.Duration
+	testsTooSlowToRetry     map[testID]time.Duration
+	testsOverTimeBudget     map[testID]time.Duration
+	packageDurations        map[string]time.Duration
+	tooManyFailures         bool
+	tooManyFailuresPackages map[string]struct{}
+	directives              map[string]packageDirectives
+	lastFailedSubtests      map[testID][]string
+	totalRetriesPerPackage  map[string]int
+	everFailedPackages      map[string]struct{}
+	failedPackages          map[string]struct{}
+	lastFailedPackages      []string
+	totalRecoveredPackages  int
+	lastTestExitCode        int
+	firstRun                bool
+	round                   int
+	buildFailures           []buildFailure
+	abortedOnBuildFailure   bool
+	unexplainedFailure      bool
+	retryBudgetExhausted    bool
+	formatMismatchWarned    bool
+
+	lastReportedTests          map[testID]struct{}
+	missingTests               map[testID]struct{}
+	everMissingTests           map[testID]struct{}
+	lastMissingTests           []testID
+	totalMissingTestRuns       int
+	totalRecoveredMissingTests int
+}
+
+// testID identifies a root test by its package import path and name. Tests
+// run with several -cpu values are identified separately for every value.
+type testID struct {
+	pkg  string
+	name string
+	cpu  string
+}
+
+func (id testID) String() string {
+	s := id.name
+	if id.pkg != "" {
+		s = id.pkg + "." + s
+	}
+	if id.cpu != "" {
+		s += " (cpu " + id.cpu + ")"
+	}
+	return s
+}
+
+// MarshalText makes structured logs show test identities as strings.
+func (id testID) MarshalText() ([]byte, error) {
+	return []byte(id.String()), nil
 }
 
 func NewRetryer(cfg Config, stdout, stderr io.Writer) *Retryer {
 	return &Retryer{
-		cfg:                    cfg,
-		stdout:                 stdout,
-		stderr:                 stderr,
-		totalRetriesLeft:       cfg.maxTotalRetries,
-		totalRetriesPerTest:    make(map[string]int),
-		everFailedTests:        make(map[string]struct{}),
-		totalSuccessfulRetries: 0,
-		lastTestExitCode:       -1,
-		firstRun:               true,
+		cfg:                     cfg,
+		stdout:                  stdout,
+		stderr:                  stderr,
+		logger:                  slog.New(slog.NewTextHandler(io.Discard, nil)),
+		totalRetriesLeft:        cfg.maxTotalRetries,
+		totalRetriesPerTest:     make(map[testID]int),
+		testRecords:             make(map[testID]*testRecord),
+		lastRetriedTests:        make(map[testID]struct{}),
+		lastFailureKinds:        make(map[testID]failureKind),
+		failureKindCounts:       make(map[failureKind]int),
+		testsNotRetriedByKind:   make(map[testID]failureKind),
+		overriddenTests:         make(map[testID][]string),
+		lastFingerprints:        make(map[testID]string),
+		identicalFailures:       make(map[testID]int),
+		deterministicFailures:   make(map[testID]string),
+		failureCauses:           make(map[testID]string),
+		retryTimeLeft:           cfg.retryTimeBudget,
+		testsTooSlowToRetry:     make(map[testID]time.Duration),
+		testsOverTimeBudget:     make(map[testID]time.Duration),
+		packageDurations:        make(map[string]time.Duration),
+		tooManyFailuresPackages: make(map[string]struct{}),
+		directives:              make(map[string]packageDirectives),
+		totalRetriesPerPackage:  make(map[string]int),
+		everFailedPackages:      make(map[string]struct{}),
+		failedPackages:          make(map[string]struct{}),
+		missingTests:            make(map[testID]struct{}),
+		everMissingTests:        make(map[testID]struct{}),
+		totalSuccessfulRetries:  0,
+		lastTestExitCode:        -1,
+		firstRun:                true,
 	}
 }
 
 func (r *Retryer) Run() (err error) {
-	if r.cfg.maxRetriesPerTest == 0 {
-		r.log("No retries allowed, going to run tests and exit")
-		err := r.test(r.cfg.testArgs, r.stdout, r.stderr)
-		if exitError, ok := err.(*exec.ExitError); ok {
-			return TestError{exitCode: exitError.ExitCode()}
+	defer func() {
+		switch err.(type) {
+		case nil, TestError, InvalidParameterError:
+		default:
+			err = InternalError{
+				err:      err,
+				exitCode: r.cfg.exitCodes.exitCode(outcomeInternalError, r.lastTestExitCode),
+			}
+		}
+	}()
+
+	logger, closeLog, err := newLogger(r.cfg, r.stderr)
+	if err != nil {
+		return err
+	}
+	defer closeLog()
+	r.logger = logger
+	for _, option := range r.cfg.optionSources {
+		r.logger.Debug("Option", "name", option.name, "value", option.value, "source", option.source)
+	}
+
+	r.testArgs, err = parseTestArgs(r.cfg.testArgs)
+	if err != nil {
+		return err
+	}
+	r.repetitions = testRepetitionsFromArgs(r.testArgs)
+	if r.cfg.overrides.hasRelativePatterns() {
+		if dir, err := r.dirImportPath(); err != nil {
+			r.logger.Warn("Couldn't resolve relative package patterns of override rules, matching them by trailing path elements", "error", err)
+		} else {
+			r.cfg.overrides.resolve(dir)
+		}
+	}
+	if r.cfg.addFormatFlags || r.cfg.console == consolePretty {
+		r.addFormatFlags()
+	}
+	if r.cfg.console == consolePretty {
+		r.renderer = newConsoleRenderer(r.stdout, useColor(r.cfg.color, r.stdout), r.retryOf)
+	}
+	if r.cfg.rawJSONFile != "" {
+		r.rawJSONFile, err = os.Create(r.cfg.rawJSONFile)
+		if err != nil {
+			return errors.Wrap(err, "create raw json file")
 		}
+		defer r.rawJSONFile.Close()
+	}
+	r.coverageProfiles, err = newCoverageProfiles(r.testArgs)
+	if err != nil {
 		return err
 	}
+	if r.coverageProfiles != nil {
+		if r.cfg.initialOutput != "" {
+			if err := r.coverageProfiles.addInitialProfile(); err != nil {
+				return err
+			}
+		}
+		defer func() {
+			if mergeErr := r.coverageProfiles.merge(); mergeErr != nil && err == nil {
+				err = mergeErr
+			}
+		}()
+	}
+	if r.cfg.historyFile != "" {
+		if r.history, err = loadTestHistory(r.cfg.historyFile); err != nil {
+			return err
+		}
+	}
+	// Dry runs don't run tests, so they don't build test binaries either.
+	if r.cfg.prebuild && !r.cfg.dryRun {
+		if r.testBinaries, err = newTestBinaries(r.cfg.testOutputTypeJSON); err != nil {
+			return err
+		}
+		defer r.testBinaries.remove()
+	}
+	start := time.Now()
 
-	r.log("Initial run of tests")
-	err = r.testAndUpdateState(r.cfg.testArgs)
+	if r.cfg.initialOutput != "" {
+		r.logger.Info("Reading output of initial run of tests", "path", r.cfg.initialOutput)
+		err = r.readInitialOutput()
+	} else {
+		if !r.cfg.areRetriesAllowed() {
+			r.logger.Info("No retries allowed, going to run tests and exit")
+		} else {
+			r.logger.Info("Initial run of tests")
+		}
+		err = r.testAndUpdateState(r.cfg.testArgs)
+	}
 	if err != nil {
 		return err
 	}
+	r.checkFailureThresholds()
+	if r.cfg.dryRun {
+		r.printRetryPlan()
+		return nil
+	}
+	if r.cfg.runMissingTests && r.cfg.areRetriesAllowed() && !r.abortedOnBuildFailure && !r.tooManyFailures && r.hasFailures() {
+		expectedTests, err := r.listTests()
+		if err != nil {
+			r.logger.Warn("Couldn't list tests to find tests that didn't run", "error", err)
+		}
+		r.updateMissingTests(expectedTests)
+	}
 
-	for len(r.lastFailedTests) > 0 {
+	for !r.abortedOnBuildFailure && !r.tooManyFailures && (r.hasFailures() || len(r.lastMissingTests) > 0) {
 		testsToRetry := r.selectTestsForRetry()
-		if len(testsToRetry) == 0 {
+		packagesToRetry := r.selectPackagesForRetry()
+		missingTests := r.selectMissingTestsToRun()
+		if len(testsToRetry) == 0 && len(packagesToRetry) == 0 && len(missingTests) == 0 {
 			break
 		}
 
-		testRunArgParts := make([]string, 0, len(testsToRetry))
-		for _, t := range testsToRetry {
-			testRunArgParts = append(testRunArgParts, "("+t+")")
-		}
-
-		r.log("Retrying tests")
-		testArgs := r.cfg.testArgs + ` "--test.run=^(` + strings.Join(testRunArgParts, "|") + `)$"`
-		err := r.testAndUpdateState(testArgs)
+		r.logger.Info("Retrying tests", "round", r.round+1)
+		err := r.testAndUpdateState(r.retryTestArgs(append(testsToRetry, missingTests...), packagesToRetry)...)
 		if err != nil {
 			return err
 		}
+		r.updateMissingTests(missingTests)
 	}
 
 	totalRetries := r.cfg.maxTotalRetries - r.totalRetriesLeft
-	r.log("Total retries:", totalRetries)
+	r.logger.Info("Total retries", "retries", totalRetries)
 	if totalRetries > 0 {
-		successfultRetriesPercentage := float64(r.totalSuccessfulRetries) / float64(totalRetries) * 100
-		r.logf("Successful retries: %v (%.0f%%)\n", r.totalSuccessfulRetries, successfultRetriesPercentage)
-		r.log("Retries per test:", r.totalRetriesPerTest)
+		successfulRetries := r.totalSuccessfulRetries + r.totalRecoveredPackages
+		successfultRetriesPercentage := float64(successfulRetries) / float64(totalRetries) * 100
+		r.logger.Info("Successful retries",
+			"retries", successfulRetries,
+			"percentage", fmt.Sprintf("%.0f%%", successfultRetriesPercentage))
+		r.logger.Info("Retries per test", "retries", r.totalRetriesPerTest)
+		if len(r.totalRetriesPerPackage) > 0 {
+			r.logger.Info("Retries per package", "retries", r.totalRetriesPerPackage)
+		}
 	}
-
-	if len(r.everFailedTests) != r.totalSuccessfulRetries {
-		return TestError{exitCode: r.lastTestExitCode}
+	r.logFailureKinds()
+	r.logRetryTime()
+	if len(r.overriddenTests) > 0 {
+		r.logger.Info("Override rules applied", "tests", r.overriddenTests)
+	}
+	if len(r.deterministicFailures) > 0 {
+		r.logger.Info("Not retried due to identical failures", "tests", r.deterministicFailures)
 	}
-	if r.failedAnyPackageBuild {
-		return TestError{exitCode: 1}
+	r.logPackageFailures()
+	r.logBuildFailures()
+	r.logMissingTests()
+	r.logVerdicts()
+	r.logVotes()
+	clusters := r.failureClusters()
+	r.logFailureClusters(clusters)
+
+	outcome := r.outcome()
+	exitCode := r.cfg.exitCodes.exitCode(outcome, r.lastTestExitCode)
+	r.logger.Info("Outcome", "outcome", outcome, "exit_code", exitCode)
+	if r.renderer != nil {
+		r.renderer.clusters(clusters)
+		r.renderer.summary(len(r.testRecords), len(r.testsWithVerdict(verdictFlaky)),
+			len(r.testsWithVerdict(verdictFailed)), outcome, time.Since(start))
+	}
+	if exitCode != 0 {
+		return TestError{exitCode: exitCode}
 	}
 
 	return nil
 }
 
-func (r *Retryer) testAndUpdateState(testArgs string) error {
-	outputBuffer := new(buffer)
+// retryTestArgs returns test arguments of the commands for a retry round:
+// one command per package, running either the selected failed tests of the
+// package or, for packages retried as a whole, all of its tests.
+func (r *Retryer) retryTestArgs(testsToRetry []testID, packagesToRetry []string) []string {
+	testsByPackage := make(map[string][]string)
+	selected := make(map[testID]struct{})
+	var packages []string
+	for _, t := range testsToRetry {
+		if _, ok := testsByPackage[t.pkg]; !ok {
+			packages = append(packages, t.pkg)
+		}
+		// Identities of a test differing in -cpu value run by the same name.
+		name := testID{pkg: t.pkg, name: t.name}
+		if _, ok := selected[name]; ok {
+			continue
+		}
+		selected[name] = struct{}{}
+		testsByPackage[t.pkg] = append(testsByPackage[t.pkg], t.name)
+	}
 
-	err := r.test(
-		testArgs,
-		io.MultiWriter(r.stdout, outputBuffer),
-		io.MultiWriter(r.stderr, outputBuffer))
+	testArgsList := make([]string, 0, len(packages)+len(packagesToRetry))
+	for _, pkg := range packages {
+		testRunArgParts := make([]string, 0, len(testsByPackage[pkg]))
+		for _, name := range testsByPackage[pkg] {
+			testRunArgParts = append(testRunArgParts, "("+name+")")
+		}
+		testArgs := r.withRetryTimeout(r.packageTestArgs(pkg), pkg, testsByPackage[pkg])
+		testArgsList = append(testArgsList, testArgs+r.votesTestArgs()+` "--test.run=^(`+strings.Join(testRunArgParts, "|")+`)$"`)
+	}
+	for _, pkg := range packagesToRetry {
+		testArgsList = append(testArgsList, r.withRetryTimeout(r.packageTestArgs(pkg), pkg, nil)+r.votesTestArgs())
+	}
 
-	if exitError, ok := err.(*exec.ExitError); err != nil && ok {
-		r.lastTestExitCode = exitError.ExitCode()
-	} else if err != nil && !ok {
-		r.lastFailedTests = nil
-		r.lastTestExitCode = -1
-		return errors.Wrap(err, "run tests")
+	return testArgsList
+}
+
+// packageTestArgs returns the test arguments with package arguments replaced
+// by the given package. The package is unknown when go test output didn't
+// name it, then the original arguments are used.
+func (r *Retryer) packageTestArgs(pkg string) string {
+	if pkg == "" {
+		return r.cfg.testArgs
 	}
+	return r.testArgs.withPackages(pkg)
+}
 
-	report, err := parseTestReport(outputBuffer, r.cfg.testOutputTypeJSON)
-	if err != nil {
-		return errors.Wrap(err, "parse test output")
+// testAndUpdateState runs a test command for every test arguments and
+// updates the state with the combined report of all of them.
+func (r *Retryer) testAndUpdateState(testArgsList ...string) error {
+	var report gtr.Report
+	r.round++
+	if r.renderer != nil {
+		r.renderer.startRound(r.round)
+	}
+	for i, testArgs := range testArgsList {
+		if r.coverageProfiles != nil {
+			var err error
+			if testArgs, err = r.coverageProfiles.testArgs(testArgs, r.round, i+1); err != nil {
+				return err
+			}
+		}
+
+		parser := newStreamParser(r.cfg.testOutputTypeJSON, r.cfg.outputTailLines)
+		stdoutWriter, stderrWriter := parser.writers()
+		var stdout io.Writer = stdoutWriter
+		if r.renderer != nil {
+			parser.onEvent = r.renderer.event
+		} else {
+			stdout = io.MultiWriter(r.stdout, stdoutWriter)
+		}
+		if r.rawJSONFile != nil {
+			stdout = io.MultiWriter(stdout, r.rawJSONFile)
+		}
+		stderr := io.MultiWriter(r.stderr, stderrWriter)
+
+		var outputFile *lockedFile
+		if r.cfg.fullOutputDir != "" {
+			var err error
+			if outputFile, err = r.createFullOutputFile(i + 1); err != nil {
+				return err
+			}
+			stdout = io.MultiWriter(stdout, outputFile)
+			stderr = io.MultiWriter(stderr, outputFile)
+		}
+
+		command, err := r.testCommand(testArgs)
+		if err != nil {
+			return err
+		}
+
+		err = r.runCommand(command, stdout, stderr)
+		stdoutWriter.Close()
+		stderrWriter.Close()
+		if outputFile != nil {
+			if closeErr := outputFile.Close(); closeErr != nil {
+				return errors.Wrap(closeErr, "write full output file")
+			}
+		}
+
+		exitError, failed := err.(*exec.ExitError)
+		if err != nil && failed {
+			r.lastTestExitCode = exitError.ExitCode()
+		} else if err != nil && !failed {
+			r.lastFailedTests = nil
+			r.lastFailedPackages = nil
+			r.lastTestExitCode = -1
+			return errors.Wrap(err, "run tests")
+		}
+
+		if format, mismatch := parser.formatMismatch(); mismatch {
+			if err := r.handleFormatMismatch(format); err != nil {
+				return err
+			}
+		}
+
+		commandReport := parser.report()
+		report.Packages = append(report.Packages, commandReport.Packages...)
+
+		if failed && !reportExplainsFailure(commandReport) {
+			r.logger.Warn("Test command failed but no failures were found in its output",
+				"exit_code", exitError.ExitCode())
+			r.unexplainedFailure = true
+		}
+
+		if r.cfg.onBuildError == onBuildErrorAbort && len(buildFailuresFromReport(commandReport)) > 0 {
+			r.logger.Warn("Build failed, aborting")
+			r.abortedOnBuildFailure = true
+			break
+		}
 	}
 	r.updateStateWithTestReport(report)
 
 	return nil
 }
 
+// createFullOutputFile creates the file for the full output of a command of
+// the current round. Stdout and stderr of the command share the file, so
+// lines keep the order they were written in.
+func (r *Retryer) createFullOutputFile(command int) (*lockedFile, error) {
+	ext := "log"
+	if r.cfg.testOutputTypeJSON {
+		ext = "json"
+	}
+	if err := os.MkdirAll(r.cfg.fullOutputDir, 0o755); err != nil {
+		return nil, errors.Wrap(err, "create full output directory")
+	}
+	path := filepath.Join(r.cfg.fullOutputDir, fmt.Sprintf("round-%d-%d.%s", r.round, command, ext))
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "create full output file")
+	}
+	return &lockedFile{file: file}, nil
+}
+
 func (r *Retryer) test(testArgs string, stdout, stderr io.Writer) error {
-	command := exec.Command(r.cfg.shellPath, "-c", r.cfg.testCommandName+" "+testArgs)
-	r.log("Running command:", strings.Join(command.Args, " "))
+	return r.runCommand(r.cfg.testCommandName+" "+testArgs, stdout, stderr)
+}
+
+func (r *Retryer) runCommand(shellCommand string, stdout, stderr io.Writer) error {
+	command := exec.Command(r.cfg.shellPath, "-c", shellCommand)
+	r.logger.Debug("Running command", "command", strings.Join(command.Args, " "))
 	command.Stdout = stdout
 	command.Stderr = stderr
 	return command.Run()
 }
 
-func (r *Retryer) selectTestsForRetry() (testsToRetry []string) {
-	for i := 0; i < len(r.lastFailedTests); i++ {
-		if r.cfg.isTotalRetriesLimitEnabled() && r.totalRetriesLeft == 0 {
+// selectTestsForRetry selects failed tests of the last round to retry,
+// handing out the total retries budget in the order of -retry-priority.
+func (r *Retryer) selectTestsForRetry() []testID {
+	r.lastRetriedTests = make(map[testID]struct{})
+	for _, failedTest := range r.prioritizedFailedTests() {
+		if r.isTooManyFailuresPackage(failedTest.pkg) || r.isDeterministicFailure(failedTest) {
+			continue
+		}
+		kind := r.lastFailureKinds[failedTest]
+		retries := r.totalRetriesPerTest[failedTest]
+		r.recordOverride(failedTest)
+		maxRetries := r.maxRetriesForFailureKind(failedTest, kind)
+		if retries < maxRetries && r.isTotalRetriesBudgetExhausted() {
 			break
 		}
-
-		failedTest := r.lastFailedTests[i]
-		if retries := r.totalRetriesPerTest[failedTest]; retries < r.cfg.maxRetriesPerTest {
-			testsToRetry = append(testsToRetry, failedTest)
+		if retries < maxRetries {
+			duration := r.lastDuration(failedTest)
+			estimate := duration * time.Duration(r.retryRepetitions().count)
+			if r.isTooSlowToRetry(failedTest, duration) || !r.admitRetryTime(failedTest, estimate) {
+				continue
+			}
+			r.lastRetriedTests[failedTest] = struct{}{}
 			r.totalRetriesPerTest[failedTest] = retries + 1
 			r.totalRetriesLeft--
+		} else if retries < r.maxRetriesForTest(failedTest) {
+			r.testsNotRetriedByKind[failedTest] = kind
 		}
 	}
-	return testsToRetry
+	// Commands run tests in report order, whatever order they were selected in.
+	return filter(r.lastFailedTests, r.isLastRetriedTest)
 }
 
-func (r *Retryer) updateStateWithTestReport(report gtr.Report) {
-	tests := testsFromReport(report)
-	tests = filter(tests, isRootTest)
+// isTotalRetriesBudgetExhausted reports whether the total retries limit
+// doesn't allow any more retries, and remembers it for the outcome.
+func (r *Retryer) isTotalRetriesBudgetExhausted() bool {
+	if r.cfg.isTotalRetriesLimitEnabled() && r.totalRetriesLeft == 0 {
+		r.retryBudgetExhausted = true
+		return true
+	}
+	return false
+}
 
-	r.lastFailedTests = testNamesFromTests(filter(tests, isFailedTest))
-	r.log("Failed tests:", r.lastFailedTests)
+// selectPackagesForRetry selects packages that failed without failed tests
+// to be retried as a whole. Every package retry counts as one retry against
+// the per-test and total limits.
+func (r *Retryer) selectPackagesForRetry() (packagesToRetry []string) {
+	if !r.cfg.retryFailedPackages {
+		return nil
+	}
+
+	for _, pkg := range r.lastFailedPackages {
+		if r.isTooManyFailuresPackage(pkg) {
+			continue
+		}
+		retries := r.totalRetriesPerPackage[pkg]
+		r.recordOverride(testID{pkg: pkg})
+		maxRetries := r.maxRetriesForTest(testID{pkg: pkg})
+		if retries < maxRetries && r.isTotalRetriesBudgetExhausted() {
+			break
+		}
+		if retries < maxRetries {
+			duration := r.packageDurations[pkg]
+			estimate := duration * time.Duration(r.retryRepetitions().count) / time.Duration(r.repetitions.count)
+			if r.isTooSlowToRetry(testID{pkg: pkg}, duration) || !r.admitRetryTime(testID{pkg: pkg}, estimate) {
+				continue
+			}
+			packagesToRetry = append(packagesToRetry, pkg)
+			r.totalRetriesPerPackage[pkg] = retries + 1
+			r.totalRetriesLeft--
+		}
+	}
+	return packagesToRetry
+}
+
+// updateStateWithTestReport records an attempt of every test identity of the
+// report. Passed attempts of retried tests count as successful retries once
+// per identity, however many times -count runs them.
+func (r *Retryer) updateStateWithTestReport(report gtr.Report) {
+	var passedTests []testID
+	r.lastReportedTests = reportedTests(report)
+	r.lastFailedSubtests = failedSubtestsFromReport(report)
+	r.lastFailedTests = nil
+	kinds := failureKindsFromReport(report)
+	fingerprints := fingerprintsFromReport(report)
+	causes := failureCausesFromReport(report, kinds)
+	r.lastFailureKinds = make(map[testID]failureKind)
+	for _, pkg := range report.Packages {
+		ids, attempts := r.retryRepetitions().attemptsFromPackage(pkg, r.round)
+		for _, id := range ids {
+			a := attempts[id]
+			r.recordVotes(id, a)
+			r.recordAttempt(id, *a)
+			if a.isFailed() {
+				r.lastFailedTests = append(r.lastFailedTests, id)
+				r.lastFailureKinds[id] = kinds[testID{pkg: id.pkg, name: id.name}]
+				r.recordFingerprint(id, fingerprints[testID{pkg: id.pkg, name: id.name}])
+				if cause, ok := causes[testID{pkg: id.pkg, name: id.name}]; ok {
+					r.failureCauses[id] = cause
+				} else {
+					delete(r.failureCauses, id)
+				}
+			} else if a.passed > 0 {
+				passedTests = append(passedTests, id)
+				delete(r.lastFingerprints, id)
+			}
+		}
+	}
+	r.logger.Debug("Failed tests", "tests", r.failedTestsWithKinds())
 
 	for _, failedTest := range r.lastFailedTests {
-		r.everFailedTests[failedTest] = struct{}{}
+		r.failureKindCounts[r.lastFailureKinds[failedTest]]++
 	}
 
 	if !r.firstRun {
-		passedTests := filter(tests, isPassedTest)
+		passedTests = filter(passedTests, r.isLastRetriedTest)
 		r.totalSuccessfulRetries += len(passedTests)
-		r.log("Passed retries:", testNamesFromTests(passedTests))
+		r.logger.Debug("Passed retries", "tests", passedTests)
 	} else {
 		r.firstRun = false
 	}
 
-	r.failedAnyPackageBuild = r.failedAnyPackageBuild || anyBuildErrorsInReport(report)
+	r.updatePackageStateWithTestReport(report)
+	r.updateBuildStateWithTestReport(report)
+}
+
+// updateBuildStateWithTestReport records build failures of the report,
+// keeping the latest output for packages that failed to build again.
+func (r *Retryer) updateBuildStateWithTestReport(report gtr.Report) {
+	for _, failure := range buildFailuresFromReport(report) {
+		recorded := false
+		for i := range r.buildFailures {
+			if r.buildFailures[i].pkg == failure.pkg {
+				r.buildFailures[i] = failure
+				recorded = true
+			}
+		}
+		if !recorded {
+			r.buildFailures = append(r.buildFailures, failure)
+		}
+	}
+}
+
+// updatePackageStateWithTestReport tracks packages that failed without any
+// failed test, e.g. because TestMain exited with non-zero code or the test
+// binary crashed outside of a test. A package recovers once it passes.
+func (r *Retryer) updatePackageStateWithTestReport(report gtr.Report) {
+	r.lastFailedPackages = nil
+	for _, pkg := range report.Packages {
+		if pkg.BuildError.Name != "" {
+			continue
+		}
+
+		if isFailedPackageWithoutFailedTests(pkg) {
+			r.lastFailedPackages = append(r.lastFailedPackages, pkg.Name)
+			r.packageDurations[pkg.Name] = pkg.Duration
+			r.failedPackages[pkg.Name] = struct{}{}
+			r.everFailedPackages[pkg.Name] = struct{}{}
+			continue
+		}
+
+		if _, ok := r.failedPackages[pkg.Name]; ok && pkg.RunError.Name == "" {
+			delete(r.failedPackages, pkg.Name)
+			r.totalRecoveredPackages++
+		}
+	}
+
+	if len(r.lastFailedPackages) > 0 {
+		r.logger.Debug("Packages failed without failed tests", "packages", r.lastFailedPackages)
+	}
+}
+
+// retryOf returns the number of the retry the test runs in during the
+// current round and the maximum retries allowed for it, or zeros if the
+// test isn't retried. Identities run with different -cpu values share the
+// name the renderer knows the test by.
+func (r *Retryer) retryOf(test testID) (retry, maxRetries int) {
+	if r.firstRun {
+		return 0, 0
+	}
+	for id := range r.lastRetriedTests {
+		if id.pkg == test.pkg && id.name == test.name && r.totalRetriesPerTest[id] > retry {
+			retry = r.totalRetriesPerTest[id]
+			maxRetries = r.maxRetriesForFailureKind(id, r.lastFailureKinds[id])
+		}
+	}
+	return retry, maxRetries
+}
+
+// hasFailures reports whether the last round had failed tests or packages.
+func (r *Retryer) hasFailures() bool {
+	return len(r.lastFailedTests) > 0 || len(r.lastFailedPackages) > 0 || r.unexplainedFailure
 }
 
-func (r *Retryer) log(args ...any) {
-	if r.cfg.verbose {
-		fmt.Fprintln(r.stdout, args...)
+func (r *Retryer) isLastRetriedTest(test testID) bool {
+	_, ok := r.lastRetriedTests[test]
+	return ok
+}
+
+func (r *Retryer) failedTestsWithKinds() []string {
+	testsWithKinds := make([]string, 0, len(r.lastFailedTests))
+	for _, test := range r.lastFailedTests {
+		testsWithKinds = append(testsWithKinds, fmt.Sprintf("%v (%v)", test, r.lastFailureKinds[test]))
 	}
+	return testsWithKinds
 }
 
-func (r *Retryer) logf(format string, args ...any) {
-	if r.cfg.verbose {
-		fmt.Fprintf(r.stdout, format, args...)
+func (r *Retryer) logFailureKinds() {
+	if len(r.failureKindCounts) == 0 {
+		return
+	}
+
+	counts := make([]any, 0, 2*len(r.failureKindCounts))
+	for _, kind := range failureKinds {
+		if count := r.failureKindCounts[kind]; count > 0 {
+			counts = append(counts, string(kind), count)
+		}
+	}
+	r.logger.Info("Failures by kind", counts...)
+
+	if len(r.testsNotRetriedByKind) > 0 {
+		r.logger.Info("Not retried due to failure kind policy", "tests", r.testsNotRetriedByKind)
+	}
+}
+
+func (r *Retryer) logPackageFailures() {
+	if len(r.everFailedPackages) == 0 {
+		return
+	}
+
+	failedPackages := make([]string, 0, len(r.failedPackages))
+	for pkg := range r.failedPackages {
+		failedPackages = append(failedPackages, pkg)
+	}
+	sort.Strings(failedPackages)
+
+	r.logger.Info("Packages failed without failed tests",
+		"packages", len(r.everFailedPackages),
+		"recovered", r.totalRecoveredPackages)
+	if len(failedPackages) > 0 {
+		r.logger.Info("Packages still failing", "packages", failedPackages)
 	}
 }
 
-func parseTestReport(r io.Reader, testOutputTypeJSON bool) (gtr.Report, error) {
-	if testOutputTypeJSON {
-		return gotest.NewJSONParser().Parse(r)
+func (r *Retryer) logBuildFailures() {
+	if len(r.buildFailures) == 0 {
+		return
+	}
+
+	for _, failure := range r.buildFailures {
+		r.logger.Info("Build failure",
+			"package", failure.pkg,
+			"vet", failure.vet,
+			"output", strings.Join(failure.output, "\n"))
 	}
-	return gotest.NewParser().Parse(r)
 }
 
 func testsFromReport(report gtr.Report) []gtr.Test {
@@ -190,26 +725,56 @@ func testsFromReport(report gtr.Report) []gtr.Test {
 	return tests
 }
 
-func anyBuildErrorsInReport(report gtr.Report) bool {
+// failedSubtestsFromReport returns failed subtests of the report by root
+// test, identified without -cpu values.
+func failedSubtestsFromReport(report gtr.Report) map[testID][]string {
+	subtests := make(map[testID][]string)
 	for _, pkg := range report.Packages {
-		if len(pkg.BuildError.Output) > 0 {
-			return true
+		for _, test := range pkg.Tests {
+			root, _, isSubtest := strings.Cut(test.Name, "/")
+			if isSubtest && isFailedTest(test) {
+				id := testID{pkg: pkg.Name, name: root}
+				subtests[id] = append(subtests[id], test.Name)
+			}
 		}
 	}
+	return subtests
+}
 
-	return false
+func failureKindsFromReport(report gtr.Report) map[testID]failureKind {
+	kinds := make(map[testID]failureKind)
+	for _, pkg := range report.Packages {
+		for test, kind := range classifyPackageFailures(pkg) {
+			kinds[testID{pkg: pkg.Name, name: test}] = kind
+		}
+	}
+	return kinds
 }
 
-func testNamesFromTests(tests []gtr.Test) []string {
-	testNames := make([]string, 0)
-	for _, test := range tests {
-		testNames = append(testNames, test.Name)
+// reportExplainsFailure reports whether the report contains a failed test,
+// a failed package or a build failure that explains a failed test command.
+func reportExplainsFailure(report gtr.Report) bool {
+	for _, pkg := range report.Packages {
+		if pkg.BuildError.Name != "" || pkg.RunError.Name != "" {
+			return true
+		}
+		if len(filter(pkg.Tests, isFailedTest)) > 0 {
+			return true
+		}
 	}
-	return testNames
+	return false
 }
 
+// isFailedPackageWithoutFailedTests reports whether the package failed
+// while none of its tests failed, so there is no test name to retry.
+func isFailedPackageWithoutFailedTests(pkg gtr.Package) bool {
+	return pkg.RunError.Name != "" && len(filter(pkg.Tests, isRootTest, isFailedTest)) == 0
+}
+
+// isFailedTest reports whether the test failed or never reported a result,
+// which happens when the test binary panics, times out or exits mid-test.
 func isFailedTest(test gtr.Test) bool {
-	return test.Result == gtr.Fail
+	return test.Result == gtr.Fail || test.Result == gtr.Unknown
 }
 
 func isPassedTest(test gtr.Test) bool {
@@ -219,3 +784,20 @@ func isPassedTest(test gtr.Test) bool {
 func isRootTest(test gtr.Test) bool {
 	return test.Level == 0
 }
+
+// lockedFile is a file safe for concurrent writes of stdout and stderr of a
+// command.
+type lockedFile struct {
+	locker sync.Mutex
+	file   *os.File
+}
+
+func (f *lockedFile) Write(p []byte) (int, error) {
+	f.locker.Lock()
+	defer f.locker.Unlock()
+	return f.file.Write(p)
+}
+
+func (f *lockedFile) Close() error {
+	return f.file.Close()
+}
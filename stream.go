@@ -0,0 +1,598 @@
+package retryer
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jstemmer/go-junit-report/v2/gtr"
+)
+
+const (
+	// maxLineSize is the maximum size of a single output line kept by the
+	// parser. Longer lines are truncated.
+	maxLineSize = 4 * 1024 * 1024
+)
+
+var (
+	regexEndTest   = regexp.MustCompile(`((?:    )*)--- (PASS|FAIL|SKIP): ([^ ]+) \((\d+\.\d+)(?: seconds|s)\)`)
+	regexStatus    = regexp.MustCompile(`^(PASS|FAIL|SKIP)$`)
+	regexCoverage  = regexp.MustCompile(`^coverage:\s+(\d+|\d+\.\d+)%\s+of\s+statements(?:\sin\s(.+))?$`)
+	regexSummary   = regexp.MustCompile(`^(\?|ok|FAIL)\s+([^ \t]+)(?:\s+(\d+\.\d+)s)?(?:\s+\(cached\))?(?:\s+coverage:\s+(?:\[no\sstatements\]|(\d+\.\d+)%\sof\sstatements(?:\sin\s.+)?))?(?:\s+(\[[^\]]+\]))?$`)
+	regexBuildLine = regexp.MustCompile(`^# (\S+)(\s+\S+)?$`)
+	// regexFailureMarker matches output lines needed to classify failures,
+	// which are kept even if they don't fit into the output tail.
+	regexFailureMarker = regexp.MustCompile(`^WARNING: DATA RACE$|race detected during execution of test$|^panic: |^fatal error: `)
+)
+
+// testEvent is an event of go test -json output, see go doc test2json.
+// Plain output lines are converted to the same events.
+type testEvent struct {
+	Action      string
+	Package     string
+	ImportPath  string
+	Test        string
+	Elapsed     float64
+	Output      string
+	FailedBuild string
+}
+
+// outputFormat is the format of go test output.
+type outputFormat int
+
+const (
+	formatUnknown outputFormat = iota
+	formatPlain
+	formatJSON
+)
+
+func (f outputFormat) String() string {
+	switch f {
+	case formatPlain:
+		return "plain"
+	case formatJSON:
+		return "json"
+	default:
+		return "unknown"
+	}
+}
+
+// streamParser parses test output as it is written and builds the report
+// incrementally, keeping at most outputTailLines lines of output per test
+// and package, so output of long test runs doesn't have to fit in memory.
+//
+// The format of the output is detected from the first non-empty line of
+// stdout, and JSON lines are parsed as test events if either the expected
+// or the detected format is JSON.
+type streamParser struct {
+	locker   sync.Mutex
+	expected outputFormat
+	detected outputFormat
+	builder  *reportBuilder
+	plain    plainConverter
+	// onEvent, if set, is called for every event parsed from stdout.
+	onEvent func(testEvent)
+}
+
+func newStreamParser(testOutputTypeJSON bool, outputTailLines int) *streamParser {
+	expected := formatPlain
+	if testOutputTypeJSON {
+		expected = formatJSON
+	}
+	return &streamParser{
+		expected: expected,
+		builder:  newReportBuilder(outputTailLines),
+	}
+}
+
+// writers return writers for stdout and stderr of the test command. Lines
+// from both streams are parsed in the order they are completed. The writers
+// must be closed to parse the last lines.
+func (p *streamParser) writers() (stdout, stderr io.WriteCloser) {
+	stdout = &lineWriter{processLine: func(line string) { p.processLine(line, true) }}
+	stderr = &lineWriter{processLine: func(line string) { p.processLine(line, false) }}
+	return stdout, stderr
+}
+
+// formatMismatch returns the detected output format and whether it differs
+// from the expected one.
+func (p *streamParser) formatMismatch() (outputFormat, bool) {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+	return p.detected, p.detected != formatUnknown && p.detected != p.expected
+}
+
+// report returns the report built from the output parsed so far.
+func (p *streamParser) report() gtr.Report {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+	return p.builder.build()
+}
+
+func (p *streamParser) processLine(line string, stdout bool) {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+
+	ev, isEvent := parseTestEvent(line)
+	if stdout && p.detected == formatUnknown && strings.TrimSpace(line) != "" {
+		p.detected = formatPlain
+		if isEvent {
+			p.detected = formatJSON
+		}
+	}
+
+	if isEvent && (p.expected == formatJSON || p.detected == formatJSON) {
+		p.processEvent(ev, stdout)
+		return
+	}
+	for _, ev := range p.plain.events(line) {
+		p.processEvent(ev, stdout)
+	}
+}
+
+func (p *streamParser) processEvent(ev testEvent, stdout bool) {
+	p.builder.processEvent(ev)
+	if stdout && p.onEvent != nil {
+		p.onEvent(ev)
+	}
+}
+
+func parseTestEvent(line string) (testEvent, bool) {
+	ev := testEvent{}
+	if !strings.HasPrefix(line, "{") {
+		return ev, false
+	}
+	if err := json.Unmarshal([]byte(line), &ev); err != nil || ev.Action == "" {
+		return ev, false
+	}
+	return ev, true
+}
+
+// lineWriter splits written data into lines.
+type lineWriter struct {
+	processLine func(string)
+	line        []byte
+	truncated   bool
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		i := bytes.IndexByte(p, '\n')
+		if i < 0 {
+			w.appendLine(p)
+			break
+		}
+		w.appendLine(p[:i])
+		w.flush()
+		p = p[i+1:]
+	}
+	return n, nil
+}
+
+func (w *lineWriter) Close() error {
+	if len(w.line) > 0 || w.truncated {
+		w.flush()
+	}
+	return nil
+}
+
+func (w *lineWriter) appendLine(p []byte) {
+	if left := maxLineSize - len(w.line); len(p) > left {
+		p = p[:left]
+		w.truncated = true
+	}
+	w.line = append(w.line, p...)
+}
+
+func (w *lineWriter) flush() {
+	w.processLine(strings.TrimSuffix(string(w.line), "\r"))
+	w.line = w.line[:0]
+	w.truncated = false
+}
+
+// test2jsonMarker starts framing lines of test binaries run with
+// -test.v=test2json.
+const test2jsonMarker = "\x16"
+
+// plainConverter converts lines of plain go test output to test events. It
+// tracks the running test, since plain output doesn't name the test every
+// output line belongs to.
+type plainConverter struct {
+	activeTest string
+}
+
+func (c *plainConverter) events(line string) []testEvent {
+	// Test binaries run with -test.v=test2json mark framing lines for
+	// test2json, e.g. when go test -json runs them with -exec.
+	line = strings.TrimPrefix(line, test2jsonMarker)
+	switch {
+	case strings.HasPrefix(line, "=== RUN "):
+		c.activeTest = strings.TrimSpace(line[8:])
+		return []testEvent{{Action: "run", Test: c.activeTest}}
+	case strings.HasPrefix(line, "=== PAUSE "):
+		c.activeTest = ""
+		return []testEvent{{Action: "pause", Test: strings.TrimSpace(line[10:])}}
+	case strings.HasPrefix(line, "=== CONT "), strings.HasPrefix(line, "=== NAME "):
+		c.activeTest = strings.TrimSpace(line[9:])
+		return []testEvent{{Action: "cont", Test: c.activeTest}}
+	}
+
+	if matches := regexEndTest.FindStringSubmatchIndex(line); matches != nil {
+		var events []testEvent
+		if matches[0] > 0 {
+			events = append(events, testEvent{Action: "output", Test: c.activeTest, Output: line[:matches[0]]})
+		}
+		elapsed, _ := strconv.ParseFloat(line[matches[8]:matches[9]], 64)
+		c.activeTest = ""
+		return append(events, testEvent{
+			Action:  strings.ToLower(line[matches[4]:matches[5]]),
+			Test:    line[matches[6]:matches[7]],
+			Elapsed: elapsed,
+		})
+	}
+
+	if matches := regexSummary.FindStringSubmatch(line); matches != nil {
+		c.activeTest = ""
+		action := "pass"
+		switch matches[1] {
+		case "FAIL":
+			action = "fail"
+		case "?":
+			action = "skip"
+		}
+		elapsed, _ := strconv.ParseFloat(matches[3], 64)
+		ev := testEvent{Action: action, Package: matches[2], Elapsed: elapsed, Output: line}
+		if matches[5] == "[build failed]" || matches[5] == "[setup failed]" {
+			ev.FailedBuild = matches[2]
+		}
+		return []testEvent{ev}
+	}
+
+	if regexStatus.MatchString(line) {
+		c.activeTest = ""
+	}
+	return []testEvent{{Action: "output", Test: c.activeTest, Output: line}}
+}
+
+// reportBuilder builds a report from test events.
+type reportBuilder struct {
+	outputTailLines int
+	nextID          int
+	packages        []gtr.Package
+	packageBuilders map[string]*packageBuilder
+	buildErrors     map[string]*buildErrorBuilder
+	buildErrorNames []string
+	activeBuild     *buildErrorBuilder
+	timestampFunc   func() time.Time
+}
+
+func newReportBuilder(outputTailLines int) *reportBuilder {
+	return &reportBuilder{
+		outputTailLines: outputTailLines,
+		nextID:          1,
+		packageBuilders: make(map[string]*packageBuilder),
+		buildErrors:     make(map[string]*buildErrorBuilder),
+		timestampFunc:   time.Now,
+	}
+}
+
+type packageBuilder struct {
+	tests    []*testBuilder
+	output   outputTail
+	coverage float64
+}
+
+type testBuilder struct {
+	test   gtr.Test
+	output outputTail
+}
+
+type buildErrorBuilder struct {
+	name   string
+	output outputTail
+}
+
+func (b *reportBuilder) processEvent(ev testEvent) {
+	if ev.Action == "build-output" {
+		b.processBuildOutput(ev)
+		return
+	}
+	if ev.Action == "build-fail" {
+		b.activeBuild = nil
+		return
+	}
+
+	output := strings.TrimSuffix(ev.Output, "\n")
+	if ev.Package == "" && ev.Test == "" && ev.Action == "output" {
+		if matches := regexBuildLine.FindStringSubmatch(output); matches != nil {
+			b.activeBuild = b.buildError(matches[1])
+			return
+		}
+		if b.activeBuild != nil {
+			b.activeBuild.output.append(output)
+			return
+		}
+	}
+	b.activeBuild = nil
+
+	if ev.Test == "" && (ev.Action == "pass" || ev.Action == "fail" || ev.Action == "skip") {
+		b.endPackage(ev)
+		return
+	}
+
+	pb := b.packageBuilder(ev.Package)
+	switch ev.Action {
+	case "run":
+		pb.tests = append(pb.tests, &testBuilder{
+			test:   gtr.NewTest(b.generateID(), ev.Test),
+			output: outputTail{limit: b.outputTailLines},
+		})
+		pb.tests[len(pb.tests)-1].test.Level = strings.Count(ev.Test, "/")
+	case "pass", "fail", "skip":
+		tb := pb.test(ev.Test)
+		if tb == nil {
+			pb.tests = append(pb.tests, &testBuilder{
+				test:   gtr.NewTest(b.generateID(), ev.Test),
+				output: outputTail{limit: b.outputTailLines},
+			})
+			tb = pb.tests[len(pb.tests)-1]
+		}
+		tb.test.Result = parseResult(ev.Action)
+		tb.test.Duration = time.Duration(ev.Elapsed * float64(time.Second))
+		tb.test.Level = strings.Count(ev.Test, "/")
+	case "output":
+		if isFramingLine(output) {
+			return
+		}
+		if ev.Test != "" {
+			if tb := pb.test(ev.Test); tb != nil {
+				tb.output.append(output)
+				return
+			}
+		}
+		if matches := regexCoverage.FindStringSubmatch(output); matches != nil {
+			pb.coverage, _ = strconv.ParseFloat(matches[1], 64)
+			return
+		}
+		pb.output.append(output)
+	}
+}
+
+func (b *reportBuilder) processBuildOutput(ev testEvent) {
+	name := strings.TrimSuffix(strings.Fields(ev.ImportPath + " ")[0], ".test")
+	output := strings.TrimSuffix(ev.Output, "\n")
+	if matches := regexBuildLine.FindStringSubmatch(output); matches != nil {
+		b.activeBuild = b.buildError(matches[1])
+		return
+	}
+	if b.activeBuild == nil {
+		b.activeBuild = b.buildError(name)
+	}
+	b.activeBuild.output.append(output)
+}
+
+func (b *reportBuilder) buildError(name string) *buildErrorBuilder {
+	be, ok := b.buildErrors[name]
+	if !ok {
+		be = &buildErrorBuilder{name: name, output: outputTail{limit: b.outputTailLines}}
+		b.buildErrors[name] = be
+		b.buildErrorNames = append(b.buildErrorNames, name)
+	}
+	return be
+}
+
+func (b *reportBuilder) packageBuilder(name string) *packageBuilder {
+	pb, ok := b.packageBuilders[name]
+	if !ok {
+		pb = &packageBuilder{output: outputTail{limit: b.outputTailLines}}
+		b.packageBuilders[name] = pb
+	}
+	return pb
+}
+
+// endPackage creates the package from collected events. Plain output names
+// the package only in its final summary line, so events of the package are
+// collected under the empty name until then.
+func (b *reportBuilder) endPackage(ev testEvent) {
+	pb, ok := b.packageBuilders[ev.Package]
+	if !ok || (len(pb.tests) == 0 && len(pb.output.tail) == 0) {
+		pb = b.packageBuilder("")
+	}
+	delete(b.packageBuilders, ev.Package)
+	delete(b.packageBuilders, "")
+
+	pkg := gtr.Package{
+		Name:      ev.Package,
+		Timestamp: b.timestampFunc(),
+		Duration:  time.Duration(ev.Elapsed * float64(time.Second)),
+		Coverage:  pb.coverage,
+	}
+
+	if ev.FailedBuild != "" || (ev.Action == "fail" && len(pb.tests) == 0 && b.hasBuildError(ev.Package)) {
+		pkg.BuildError = b.takeBuildError(ev.Package)
+		if pkg.BuildError.Name == "" {
+			pkg.BuildError.Name = ev.Package
+		}
+		pkg.BuildError.Duration = pkg.Duration
+		b.packages = append(b.packages, pkg)
+		return
+	}
+
+	failedTests := false
+	for _, tb := range pb.tests {
+		tb.test.Output = tb.output.lines()
+		pkg.Tests = append(pkg.Tests, tb.test)
+		failedTests = failedTests || isFailedTest(tb.test)
+	}
+	pkg.Output = pb.output.lines()
+
+	if ev.Action == "fail" && !failedTests {
+		pkg.RunError = gtr.Error{Name: ev.Package, Output: pkg.Output}
+		pkg.Output = nil
+	}
+
+	b.packages = append(b.packages, pkg)
+}
+
+func (b *reportBuilder) hasBuildError(pkg string) bool {
+	_, ok := b.buildErrors[pkg]
+	return ok
+}
+
+// takeBuildError removes and returns the build error of the package. Errors
+// of external test packages are reported as errors of the package itself.
+func (b *reportBuilder) takeBuildError(pkg string) gtr.Error {
+	for _, name := range []string{pkg, pkg + "_test"} {
+		be, ok := b.buildErrors[name]
+		if !ok {
+			continue
+		}
+		delete(b.buildErrors, name)
+		b.activeBuild = nil
+		return gtr.Error{ID: b.generateID(), Name: be.name, Output: be.output.lines()}
+	}
+	return gtr.Error{}
+}
+
+// build returns the report. Packages that never finished and build errors
+// not attributed to any package, like vet errors reported under a "[pkg]"
+// header, are added as separate packages.
+func (b *reportBuilder) build() gtr.Report {
+	packages := append([]gtr.Package(nil), b.packages...)
+
+	names := make([]string, 0, len(b.packageBuilders))
+	for name := range b.packageBuilders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		pb := b.packageBuilders[name]
+		if len(pb.tests) == 0 && len(pb.output.tail) == 0 {
+			continue
+		}
+		pkg := gtr.Package{Name: name, Timestamp: b.timestampFunc(), Coverage: pb.coverage, Output: pb.output.lines()}
+		for _, tb := range pb.tests {
+			test := tb.test
+			test.Output = tb.output.lines()
+			pkg.Tests = append(pkg.Tests, test)
+		}
+		packages = append(packages, pkg)
+	}
+
+	for _, name := range b.buildErrorNames {
+		be, ok := b.buildErrors[name]
+		if !ok {
+			continue
+		}
+		packages = append(packages, gtr.Package{
+			Name:       name,
+			Timestamp:  b.timestampFunc(),
+			BuildError: gtr.Error{Name: name, Output: be.output.lines()},
+		})
+	}
+
+	return gtr.Report{Packages: packages}
+}
+
+func (b *reportBuilder) generateID() int {
+	id := b.nextID
+	b.nextID++
+	return id
+}
+
+// test returns the most recently started test with the given name.
+func (pb *packageBuilder) test(name string) *testBuilder {
+	for i := len(pb.tests) - 1; i >= 0; i-- {
+		if pb.tests[i].test.Name == name {
+			return pb.tests[i]
+		}
+	}
+	return nil
+}
+
+// outputTail keeps the last limit lines of output, or all of them if limit
+// is 0. The first limit dropped lines needed to classify failures are kept
+// separately, so output repeating them doesn't grow without bound.
+type outputTail struct {
+	limit   int
+	tail    []string
+	pinned  []string
+	dropped int
+}
+
+func (t *outputTail) append(line string) {
+	t.tail = append(t.tail, line)
+	if t.limit <= 0 || len(t.tail) < 2*t.limit {
+		return
+	}
+
+	drop := len(t.tail) - t.limit
+	for _, dropped := range t.tail[:drop] {
+		if len(t.pinned) < t.limit && regexFailureMarker.MatchString(dropped) {
+			t.pinned = append(t.pinned, dropped)
+		}
+	}
+	t.dropped += drop
+	t.tail = append(make([]string, 0, 2*t.limit), t.tail[drop:]...)
+}
+
+func (t *outputTail) lines() []string {
+	tail := t.tail
+	pinned := t.pinned
+	dropped := t.dropped
+	if t.limit > 0 && len(tail) > t.limit {
+		drop := len(tail) - t.limit
+		pinned = append([]string(nil), pinned...)
+		for _, line := range tail[:drop] {
+			if len(pinned) < t.limit && regexFailureMarker.MatchString(line) {
+				pinned = append(pinned, line)
+			}
+		}
+		dropped += drop
+		tail = tail[drop:]
+	}
+	if dropped == 0 {
+		return append([]string(nil), tail...)
+	}
+
+	lines := make([]string, 0, len(pinned)+1+len(tail))
+	lines = append(lines, pinned...)
+	if omitted := dropped - len(pinned); omitted > 0 {
+		lines = append(lines, fmt.Sprintf("... %v lines omitted ...", omitted))
+	}
+	return append(lines, tail...)
+}
+
+// isFramingLine reports whether the output line is printed by the go test
+// runner to mark test boundaries and results. Such lines are reported as
+// separate events and not kept in the output.
+func isFramingLine(line string) bool {
+	return strings.HasPrefix(line, "=== RUN ") ||
+		strings.HasPrefix(line, "=== PAUSE ") ||
+		strings.HasPrefix(line, "=== CONT ") ||
+		strings.HasPrefix(line, "=== NAME ") ||
+		regexEndTest.MatchString(line) ||
+		regexStatus.MatchString(line) ||
+		regexSummary.MatchString(line)
+}
+
+func parseResult(action string) gtr.Result {
+	switch action {
+	case "pass":
+		return gtr.Pass
+	case "fail":
+		return gtr.Fail
+	case "skip":
+		return gtr.Skip
+	default:
+		return gtr.Unknown
+	}
+}
@@ -0,0 +1,72 @@
+package retryer
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOutputTailKeepsFailureMarkers(t *testing.T) {
+	tail := outputTail{limit: 2}
+	tail.append("panic: boom")
+	for i := 0; i < 10; i++ {
+		tail.append(fmt.Sprint("line ", i))
+	}
+
+	expected := []string{"panic: boom", "... 8 lines omitted ...", "line 8", "line 9"}
+	assert.Equal(t, expected, tail.lines())
+	assert.Equal(t, expected, tail.lines())
+}
+
+func TestOutputTailLimitsRepeatedFailureMarkers(t *testing.T) {
+	tail := outputTail{limit: 2}
+	for i := 0; i < 10; i++ {
+		tail.append(fmt.Sprint("panic: ", i))
+	}
+
+	assert.Equal(t, []string{"panic: 0", "panic: 1", "... 6 lines omitted ...", "panic: 8", "panic: 9"}, tail.lines())
+
+	for i := 0; i < 1000; i++ {
+		tail.append("WARNING: DATA RACE")
+	}
+	assert.Len(t, tail.pinned, 2)
+}
+
+func TestStreamParserSplitsLinesAcrossWrites(t *testing.T) {
+	parser := newStreamParser(false, 0)
+	w, _ := parser.writers()
+	for _, chunk := range []string{"=== RUN   Test", "A\n--- FAIL: TestA (0.00s)\nFAIL\nFAIL\tpkg", "\t0.01s\n"} {
+		_, err := w.Write([]byte(chunk))
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, w.Close())
+
+	report := parser.report()
+	if assert.Len(t, report.Packages, 1) && assert.Len(t, report.Packages[0].Tests, 1) {
+		assert.Equal(t, "pkg", report.Packages[0].Name)
+		assert.Equal(t, "TestA", report.Packages[0].Tests[0].Name)
+		assert.True(t, isFailedTest(report.Packages[0].Tests[0]))
+	}
+}
+
+func TestStreamParserDetectsFormatMismatch(t *testing.T) {
+	parser := newStreamParser(false, 0)
+	stdout, stderr := parser.writers()
+	_, err := stderr.Write([]byte("go: downloading example.com/module v1.0.0\n"))
+	assert.NoError(t, err)
+	_, err = stdout.Write([]byte(`{"Action":"run","Package":"pkg","Test":"TestA"}` + "\n" +
+		`{"Action":"fail","Package":"pkg","Test":"TestA","Elapsed":0.01}` + "\n" +
+		`{"Action":"fail","Package":"pkg","Elapsed":0.02}` + "\n"))
+	assert.NoError(t, err)
+	assert.NoError(t, stdout.Close())
+	assert.NoError(t, stderr.Close())
+
+	format, mismatch := parser.formatMismatch()
+	assert.True(t, mismatch)
+	assert.Equal(t, formatJSON, format)
+	report := parser.report()
+	if assert.Len(t, report.Packages, 1) && assert.Len(t, report.Packages[0].Tests, 1) {
+		assert.True(t, isFailedTest(report.Packages[0].Tests[0]))
+	}
+}
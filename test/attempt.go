@@ -0,0 +1,10 @@
+package test
+
+// attemptResult describes an attempt of a flaky test. Its branches show in
+// coverage profiles which attempts ran.
+func attemptResult(failed bool) string {
+	if failed {
+		return "failed attempt"
+	}
+	return "passed attempt"
+}
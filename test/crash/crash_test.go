@@ -0,0 +1,66 @@
+package crash
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+var configPath string
+
+type Config struct {
+	PackageFailuresLeft int `yaml:"package_failures_left"`
+}
+
+func init() {
+	flag.StringVar(&configPath, "config-path", "", "path to config")
+}
+
+func TestMain(m *testing.M) {
+	flag.Parse()
+
+	if configPath != "" {
+		config := readConfig()
+		if config.PackageFailuresLeft != 0 {
+			config.PackageFailuresLeft--
+			writeConfig(config)
+			fmt.Println("TestMain failed")
+			os.Exit(1)
+		}
+	}
+
+	os.Exit(m.Run())
+}
+
+func TestSuccess(t *testing.T) {
+	t.Log("working...")
+}
+
+func readConfig() *Config {
+	configBytes, err := os.ReadFile(configPath)
+	if err != nil {
+		log.Fatalf("Read config file error: %v", err)
+	}
+
+	config := new(Config)
+	err = yaml.Unmarshal(configBytes, config)
+	if err != nil {
+		log.Fatalf("Parse config file error: %v", err)
+	}
+	return config
+}
+
+func writeConfig(config *Config) {
+	configBytes, err := yaml.Marshal(config)
+	if err != nil {
+		log.Fatalf("Marshal config error: %v", err)
+	}
+	err = os.WriteFile(configPath, configBytes, 0644)
+	if err != nil {
+		log.Fatalf("Write config file error: %v", err)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"log"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 	"gopkg.in/yaml.v3"
@@ -54,6 +55,44 @@ func TestFlaky(t *testing.T) {
 	}
 }
 
+//retryer:retries=2 reason="fails until its config counter runs out"
+func TestDirectiveFlaky(t *testing.T) {
+	TestFlaky(t)
+}
+
+func TestDirectiveFlakySubtest(t *testing.T) {
+	//retryer:retries=2
+	t.Run("flaky case", TestFlaky)
+	t.Run("stable case", TestSuccess)
+}
+
+func TestCoverageOfFlaky(t *testing.T) {
+	require.NotNil(t, config, "no config")
+	t.Log(attemptResult(config.FlakyTestFailuresLeft != 0))
+	TestFlaky(t)
+}
+
+func TestAfterFlaky(t *testing.T) {
+	t.Log(logMessage)
+}
+
+func TestTimeout(t *testing.T) {
+	t.Log(logMessage)
+	time.Sleep(5 * time.Second)
+}
+
+func TestRace(t *testing.T) {
+	t.Log(logMessage)
+	counter := 0
+	done := make(chan struct{})
+	go func() {
+		counter++
+		close(done)
+	}()
+	counter++
+	<-done
+}
+
 func readConfig() {
 	flag.Parse()
 
@@ -0,0 +1,343 @@
+package retryer
+
+import (
+	"strings"
+)
+
+// goTestBoolFlags are go test flags that don't take a separate value.
+// Any other known flag written without "=" consumes the next argument.
+var goTestBoolFlags = map[string]struct{}{
+	"a": {}, "n": {}, "x": {}, "v": {}, "c": {}, "i": {},
+	"race": {}, "msan": {}, "asan": {}, "cover": {}, "json": {},
+	"short": {}, "failfast": {}, "benchmem": {}, "trimpath": {},
+	"work": {}, "linkshared": {}, "modcacherw": {}, "buildvcs": {},
+	"fullpath": {},
+}
+
+// goTestValueFlags are go test flags that take a value.
+var goTestValueFlags = map[string]struct{}{
+	"p": {}, "C": {}, "asmflags": {}, "buildmode": {}, "compiler": {},
+	"gccgoflags": {}, "gcflags": {}, "installsuffix": {}, "ldflags": {},
+	"mod": {}, "modfile": {}, "overlay": {}, "pgo": {}, "pkgdir": {},
+	"tags": {}, "toolexec": {}, "covermode": {}, "coverpkg": {},
+	"exec": {}, "o": {}, "vet": {},
+	"bench": {}, "benchtime": {}, "count": {}, "coverprofile": {},
+	"cpu": {}, "fuzz": {}, "fuzzminimizetime": {}, "fuzztime": {},
+	"list": {}, "parallel": {}, "run": {}, "skip": {}, "timeout": {},
+	"shuffle": {}, "blockprofile": {}, "blockprofilerate": {},
+	"cpuprofile": {}, "memprofile": {}, "memprofilerate": {},
+	"mutexprofile": {}, "mutexprofilefraction": {}, "outputdir": {},
+	"trace": {},
+}
+
+// testArg is a single shell word of the test arguments. raw keeps the word
+// as it was written, so commands rebuilt from the arguments keep their shell
+// semantics (quoting, variables, globs).
+type testArg struct {
+	raw   string
+	value string
+}
+
+// testArgs are test arguments split into shell words, with package
+// arguments located the same way go test does it: packages are non-flag
+// arguments that precede "-args" and the first flag go test doesn't know.
+type testArgs struct {
+	args     []testArg
+	packages map[int]struct{}
+}
+
+func parseTestArgs(s string) (testArgs, error) {
+	args, err := splitShellWords(s)
+	if err != nil {
+		return testArgs{}, err
+	}
+
+	parsed := testArgs{args: args, packages: make(map[int]struct{})}
+	packagesAllowed := true
+	for i := 0; i < len(args); i++ {
+		value := args[i].value
+		if !strings.HasPrefix(value, "-") || value == "-" {
+			if packagesAllowed {
+				parsed.packages[i] = struct{}{}
+			}
+			continue
+		}
+
+		name, _, hasValue := strings.Cut(strings.TrimLeft(value, "-"), "=")
+		if name == "args" {
+			break
+		}
+		name = strings.TrimPrefix(name, "test.")
+		if _, ok := goTestBoolFlags[name]; ok {
+			continue
+		}
+		if _, ok := goTestValueFlags[name]; ok {
+			if !hasValue {
+				i++
+			}
+			continue
+		}
+		packagesAllowed = false
+	}
+
+	return parsed, nil
+}
+
+// withPackages returns the test arguments with package arguments replaced by
+// the given packages.
+func (a testArgs) withPackages(packages ...string) string {
+	words := make([]string, 0, len(a.args)+len(packages))
+	packagesAdded := false
+	for i, arg := range a.args {
+		if _, ok := a.packages[i]; !ok {
+			words = append(words, arg.raw)
+			continue
+		}
+		if !packagesAdded {
+			words = append(words, packages...)
+			packagesAdded = true
+		}
+	}
+	if !packagesAdded {
+		words = insertBeforeBinaryArgs(words, packages)
+	}
+	return strings.Join(words, " ")
+}
+
+// insertBeforeBinaryArgs inserts packages before "-args", which passes the
+// rest of the command line to the test binary.
+func insertBeforeBinaryArgs(words, packages []string) []string {
+	for i, word := range words {
+		if word == "-args" || word == "--args" {
+			result := make([]string, 0, len(words)+len(packages))
+			result = append(result, words[:i]...)
+			result = append(result, packages...)
+			return append(result, words[i:]...)
+		}
+	}
+	return append(words, packages...)
+}
+
+// splitShellWords splits s into words the way a POSIX shell does, honoring
+// single quotes, double quotes and backslash escapes.
+func splitShellWords(s string) ([]testArg, error) {
+	var (
+		args    []testArg
+		raw     strings.Builder
+		value   strings.Builder
+		inWord  bool
+		quote   rune
+		escaped bool
+	)
+
+	flush := func() {
+		if inWord {
+			args = append(args, testArg{raw: raw.String(), value: value.String()})
+		}
+		raw.Reset()
+		value.Reset()
+		inWord = false
+	}
+
+	for _, c := range s {
+		switch {
+		case escaped:
+			raw.WriteRune(c)
+			value.WriteRune(c)
+			escaped = false
+			continue
+		case c == '\\' && quote != '\'':
+			raw.WriteRune(c)
+			escaped = true
+			inWord = true
+			continue
+		case quote != 0:
+			raw.WriteRune(c)
+			if c == quote {
+				quote = 0
+			} else {
+				value.WriteRune(c)
+			}
+			continue
+		case c == '\'' || c == '"':
+			raw.WriteRune(c)
+			quote = c
+			inWord = true
+			continue
+		case c == ' ' || c == '\t' || c == '\n':
+			flush()
+			continue
+		}
+		raw.WriteRune(c)
+		value.WriteRune(c)
+		inWord = true
+	}
+
+	if quote != 0 || escaped {
+		return nil, InvalidParameterError{"Unterminated quote or escape in test arguments"}
+	}
+	flush()
+
+	return args, nil
+}
+
+// flagValue returns the value of the last occurrence of a go test flag
+// before "-args". Flags can be written with one or two dashes and with the
+// "test." prefix.
+func (a testArgs) flagValue(name string) (value string, ok bool) {
+	a.forEachFlag(func(i int, flagName string, hasValue bool) {
+		if flagName != name {
+			return
+		}
+		switch {
+		case hasValue:
+			_, value, _ = strings.Cut(a.args[i].value, "=")
+			ok = true
+		case i+1 < len(a.args):
+			value = a.args[i+1].value
+			ok = true
+		}
+	})
+	return value, ok
+}
+
+// withoutFlags returns the test arguments without the given go test flags
+// and their values.
+func (a testArgs) withoutFlags(names ...string) testArgs {
+	removed := make(map[int]struct{})
+	a.forEachFlag(func(i int, flagName string, hasValue bool) {
+		for _, name := range names {
+			if flagName != name {
+				continue
+			}
+			removed[i] = struct{}{}
+			if _, isBool := goTestBoolFlags[name]; !isBool && !hasValue {
+				removed[i+1] = struct{}{}
+			}
+		}
+	})
+
+	result := testArgs{packages: make(map[int]struct{})}
+	for i, arg := range a.args {
+		if _, ok := removed[i]; ok {
+			continue
+		}
+		if _, ok := a.packages[i]; ok {
+			result.packages[len(result.args)] = struct{}{}
+		}
+		result.args = append(result.args, arg)
+	}
+	return result
+}
+
+// hasFlag reports whether a go test flag is set before "-args".
+func (a testArgs) hasFlag(name string) (ok bool) {
+	a.forEachFlag(func(_ int, flagName string, _ bool) {
+		ok = ok || flagName == name
+	})
+	return ok
+}
+
+// withFlag returns the test arguments with a go test flag added before
+// "-args". The value is quoted for the shell.
+func (a testArgs) withFlag(name, value string) testArgs {
+	return a.withArg(testArg{raw: "-" + name + "=" + shellQuote(value), value: "-" + name + "=" + value})
+}
+
+// withBoolFlag returns the test arguments with a boolean go test flag added
+// before "-args".
+func (a testArgs) withBoolFlag(name string) testArgs {
+	return a.withArg(testArg{raw: "-" + name, value: "-" + name})
+}
+
+func (a testArgs) withArg(flagArg testArg) testArgs {
+	insertAt := len(a.args)
+	for i, arg := range a.args {
+		if arg.value == "-args" || arg.value == "--args" {
+			insertAt = i
+			break
+		}
+	}
+
+	result := testArgs{packages: make(map[int]struct{})}
+	result.args = append(result.args, a.args[:insertAt]...)
+	result.args = append(result.args, flagArg)
+	result.args = append(result.args, a.args[insertAt:]...)
+	for i := range a.packages {
+		if i >= insertAt {
+			i++
+		}
+		result.packages[i] = struct{}{}
+	}
+	return result
+}
+
+func (a testArgs) String() string {
+	words := make([]string, 0, len(a.args))
+	for _, arg := range a.args {
+		words = append(words, arg.raw)
+	}
+	return strings.Join(words, " ")
+}
+
+// forEachFlag calls f for every known go test flag before "-args" with the
+// flag name stripped of dashes and the "test." prefix.
+func (a testArgs) forEachFlag(f func(i int, name string, hasValue bool)) {
+	for i := 0; i < len(a.args); i++ {
+		value := a.args[i].value
+		if _, isPackage := a.packages[i]; isPackage || !strings.HasPrefix(value, "-") || value == "-" {
+			continue
+		}
+
+		name, _, hasValue := strings.Cut(strings.TrimLeft(value, "-"), "=")
+		if name == "args" {
+			return
+		}
+		name = strings.TrimPrefix(name, "test.")
+		f(i, name, hasValue)
+		if _, ok := goTestValueFlags[name]; ok && !hasValue {
+			i++
+		}
+	}
+}
+
+// binaryArgs returns words go test passes to the test binary as they are:
+// flags go test doesn't know, their values and everything after "-args".
+func (a testArgs) binaryArgs() []string {
+	var words []string
+	for i := 0; i < len(a.args); i++ {
+		if _, isPackage := a.packages[i]; isPackage {
+			continue
+		}
+		value := a.args[i].value
+		if !strings.HasPrefix(value, "-") || value == "-" {
+			words = append(words, a.args[i].raw)
+			continue
+		}
+
+		name, _, hasValue := strings.Cut(strings.TrimLeft(value, "-"), "=")
+		if name == "args" {
+			for _, arg := range a.args[i+1:] {
+				words = append(words, arg.raw)
+			}
+			break
+		}
+		name = strings.TrimPrefix(name, "test.")
+		if _, ok := goTestValueFlags[name]; ok {
+			if !hasValue {
+				i++
+			}
+			continue
+		}
+		if _, ok := goTestBoolFlags[name]; ok {
+			continue
+		}
+		words = append(words, a.args[i].raw)
+	}
+	return words
+}
+
+// shellQuote quotes s as a single shell word.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,83 @@
+package retryer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTestArgsWithPackages(t *testing.T) {
+	testCases := []struct {
+		name     string
+		testArgs string
+		expected string
+	}{
+		{
+			name:     "NoPackages",
+			testArgs: "-v -count=1",
+			expected: "-v -count=1 pkg",
+		},
+		{
+			name:     "PackagesReplaced",
+			testArgs: "-v ./a ./b/... -count=1",
+			expected: "-v pkg -count=1",
+		},
+		{
+			name:     "FlagValueIsNotPackage",
+			testArgs: `-run "^(TestA|TestB)$" -timeout 1m ./...`,
+			expected: `-run "^(TestA|TestB)$" -timeout 1m pkg`,
+		},
+		{
+			name:     "NoPackagesAfterUnknownFlag",
+			testArgs: "./a -config-path /tmp/config.yaml",
+			expected: "pkg -config-path /tmp/config.yaml",
+		},
+		{
+			name:     "NoPackagesAfterArgs",
+			testArgs: "-v -args value",
+			expected: "-v pkg -args value",
+		},
+		{
+			name:     "QuotingKept",
+			testArgs: `'./a b' -ldflags='-X main.version=1' $PKG`,
+			expected: `pkg -ldflags='-X main.version=1'`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			args, err := parseTestArgs(tc.testArgs)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, args.withPackages("pkg"))
+		})
+	}
+}
+
+func TestParseTestArgsUnterminatedQuote(t *testing.T) {
+	_, err := parseTestArgs(`-run "^TestA$`)
+	require.Error(t, err)
+	assert.IsType(t, InvalidParameterError{}, err)
+}
+
+func TestTestArgsFlags(t *testing.T) {
+	args, err := parseTestArgs(`-v -count 1 "-run=^(TestA|TestB)$" ./... -args -run=binary`)
+	require.NoError(t, err)
+
+	run, ok := args.flagValue("run")
+	assert.True(t, ok)
+	assert.Equal(t, "^(TestA|TestB)$", run)
+	count, ok := args.flagValue("count")
+	assert.True(t, ok)
+	assert.Equal(t, "1", count)
+
+	listArgs := args.withoutFlags("run", "count", "v").withFlag("list", "^Test'A$")
+	assert.Equal(t, `./... -list='^Test'\''A$' -args -run=binary`, listArgs.String())
+	assert.Equal(t, `pkg -list='^Test'\''A$' -args -run=binary`, listArgs.withPackages("pkg"))
+}
+
+func TestFirstPatternElement(t *testing.T) {
+	assert.Equal(t, "^TestA$", firstPatternElement("^TestA$/sub"))
+	assert.Equal(t, "^(TestA|Test[/]B)$", firstPatternElement("^(TestA|Test[/]B)$/sub"))
+	assert.Equal(t, `Test\/A`, firstPatternElement(`Test\/A`))
+}
@@ -0,0 +1,81 @@
+package retryer
+
+import (
+	"fmt"
+	"sort"
+)
+
+const (
+	thresholdScopeRun     = "run"
+	thresholdScopePackage = "package"
+)
+
+// checkFailureThresholds decides after the first round whether failures are
+// too broad to be flaky: with the run scope retries are skipped entirely if
+// the failed tests of the run exceed -max-failures or -max-failure-percent of
+// the executed tests; with the package scope only packages exceeding them
+// aren't retried.
+func (r *Retryer) checkFailureThresholds() {
+	if r.cfg.maxFailures == 0 && r.cfg.maxFailurePercent == 0 {
+		return
+	}
+
+	failed := make(map[string]map[string]struct{})
+	for _, test := range r.lastFailedTests {
+		if failed[test.pkg] == nil {
+			failed[test.pkg] = make(map[string]struct{})
+		}
+		failed[test.pkg][test.name] = struct{}{}
+	}
+	executed := make(map[string]int)
+	for test := range r.lastReportedTests {
+		executed[test.pkg]++
+	}
+
+	if r.cfg.failureThresholdScope == thresholdScopeRun {
+		totalFailed, totalExecuted := 0, len(r.lastReportedTests)
+		for _, tests := range failed {
+			totalFailed += len(tests)
+		}
+		if limit, exceeded := r.exceedsFailureThresholds(totalFailed, totalExecuted); exceeded {
+			r.tooManyFailures = true
+			r.logger.Warn("Too many failures, not retrying: failures look like a real breakage rather than flakiness",
+				"failed", totalFailed, "executed", totalExecuted, "limit", limit)
+		}
+		return
+	}
+
+	pkgs := make([]string, 0, len(failed))
+	for pkg := range failed {
+		pkgs = append(pkgs, pkg)
+	}
+	sort.Strings(pkgs)
+	for _, pkg := range pkgs {
+		if limit, exceeded := r.exceedsFailureThresholds(len(failed[pkg]), executed[pkg]); exceeded {
+			r.tooManyFailuresPackages[pkg] = struct{}{}
+			r.logger.Warn("Too many failures in package, not retrying its tests",
+				"package", pkg, "failed", len(failed[pkg]), "executed", executed[pkg], "limit", limit)
+		}
+	}
+}
+
+// exceedsFailureThresholds returns the first threshold that the failed tests
+// exceed.
+func (r *Retryer) exceedsFailureThresholds(failed, executed int) (limit string, exceeded bool) {
+	if r.cfg.maxFailures > 0 && failed > r.cfg.maxFailures {
+		return fmt.Sprintf("max-failures=%v", r.cfg.maxFailures), true
+	}
+	if r.cfg.maxFailurePercent > 0 && executed > 0 &&
+		float64(failed)*100/float64(executed) > r.cfg.maxFailurePercent {
+		return fmt.Sprintf("max-failure-percent=%v (%.0f%% failed)",
+			r.cfg.maxFailurePercent, float64(failed)*100/float64(executed)), true
+	}
+	return "", false
+}
+
+// isTooManyFailuresPackage reports whether tests of the package aren't
+// retried because too many of them failed.
+func (r *Retryer) isTooManyFailuresPackage(pkg string) bool {
+	_, ok := r.tooManyFailuresPackages[pkg]
+	return ok
+}
@@ -0,0 +1,63 @@
+package retryer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFailureThresholdsPerPackage(t *testing.T) {
+	plan, _ := dryRun(t, Config{
+		maxRetriesPerTest:     2,
+		maxFailurePercent:     60,
+		failureThresholdScope: thresholdScopePackage,
+	}, "=== RUN   TestA\n"+
+		"--- FAIL: TestA (0.00s)\n"+
+		"=== RUN   TestB\n"+
+		"--- FAIL: TestB (0.00s)\n"+
+		"FAIL\n"+
+		"FAIL\tbroken\t0.01s\n"+
+		"=== RUN   TestC\n"+
+		"--- FAIL: TestC (0.00s)\n"+
+		"=== RUN   TestD\n"+
+		"--- PASS: TestD (0.00s)\n"+
+		"FAIL\n"+
+		"FAIL\tflaky\t0.01s\n")
+
+	assert.Equal(t, `Retry round 1:
+  package flaky
+    TestC (fail): retry 1/2
+Not retried:
+  broken.TestA (fail): too many failures in package
+  broken.TestB (fail): too many failures in package
+Commands:
+  go test -v flaky "--test.run=^((TestC))$"
+Total retries: 1 planned, unlimited
+`, plan)
+}
+
+func TestExceedsFailureThresholds(t *testing.T) {
+	testCases := []struct {
+		name       string
+		maxCount   int
+		maxPercent float64
+		failed     int
+		executed   int
+		expected   bool
+	}{
+		{name: "no limits", failed: 10, executed: 10, expected: false},
+		{name: "count within", maxCount: 3, failed: 3, executed: 10, expected: false},
+		{name: "count exceeded", maxCount: 3, failed: 4, executed: 10, expected: true},
+		{name: "percent within", maxPercent: 50, failed: 5, executed: 10, expected: false},
+		{name: "percent exceeded", maxPercent: 50, failed: 6, executed: 10, expected: true},
+		{name: "nothing executed", maxPercent: 50, failed: 1, executed: 0, expected: false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := &Retryer{cfg: Config{maxFailures: tc.maxCount, maxFailurePercent: tc.maxPercent}}
+			_, exceeded := r.exceedsFailureThresholds(tc.failed, tc.executed)
+			assert.Equal(t, tc.expected, exceeded)
+		})
+	}
+}
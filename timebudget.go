@@ -0,0 +1,49 @@
+package retryer
+
+import "time"
+
+// isTooSlowToRetry reports whether a run of the test in its last attempt, or
+// the last run of the package retried as a whole if the test name is empty,
+// took longer than -max-retry-test-duration. Such tests are remembered for
+// the summary.
+func (r *Retryer) isTooSlowToRetry(test testID, duration time.Duration) bool {
+	if r.cfg.maxRetryTestDuration == 0 || duration <= r.cfg.maxRetryTestDuration {
+		return false
+	}
+	if _, ok := r.testsTooSlowToRetry[test]; !ok {
+		r.logger.Debug("Test is too slow to retry", "test", test, "duration", duration)
+	}
+	r.testsTooSlowToRetry[test] = duration
+	return true
+}
+
+// admitRetryTime charges the duration of a retry, estimated by the previous
+// attempt for the runs of the retry, to -retry-time-budget. A retry that
+// doesn't fit in the time left isn't admitted, while shorter retries of other
+// tests still can be.
+func (r *Retryer) admitRetryTime(test testID, estimate time.Duration) bool {
+	if r.cfg.retryTimeBudget == 0 {
+		return true
+	}
+	if estimate > r.retryTimeLeft {
+		r.retryBudgetExhausted = true
+		r.testsOverTimeBudget[test] = estimate
+		return false
+	}
+	r.retryTimeLeft -= estimate
+	delete(r.testsOverTimeBudget, test)
+	return true
+}
+
+func (r *Retryer) logRetryTime() {
+	if r.cfg.retryTimeBudget != 0 {
+		r.logger.Info("Retry time budget",
+			"budget", r.cfg.retryTimeBudget, "spent", r.cfg.retryTimeBudget-r.retryTimeLeft)
+	}
+	if len(r.testsOverTimeBudget) > 0 {
+		r.logger.Info("Not retried due to retry time budget", "tests", r.testsOverTimeBudget)
+	}
+	if len(r.testsTooSlowToRetry) > 0 {
+		r.logger.Info("Not retried due to duration", "tests", r.testsTooSlowToRetry)
+	}
+}
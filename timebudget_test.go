@@ -0,0 +1,38 @@
+package retryer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryTimeBudget(t *testing.T) {
+	plan, _ := dryRun(t, Config{
+		maxRetriesPerTest:    1,
+		retryTimeBudget:      2 * time.Second,
+		maxRetryTestDuration: 5 * time.Second,
+	}, "=== RUN   TestSlow\n"+
+		"--- FAIL: TestSlow (10.00s)\n"+
+		"=== RUN   TestMedium\n"+
+		"--- FAIL: TestMedium (3.00s)\n"+
+		"=== RUN   TestFast\n"+
+		"--- FAIL: TestFast (1.50s)\n"+
+		"=== RUN   TestFaster\n"+
+		"--- FAIL: TestFaster (0.50s)\n"+
+		"FAIL\n"+
+		"FAIL\tpkg\t15.01s\n")
+
+	assert.Equal(t, `Retry round 1:
+  package pkg
+    TestFast (fail): retry 1/1
+    TestFaster (fail): retry 1/1
+Not retried:
+  pkg.TestSlow (fail): last attempt took 10s, longer than max retry test duration
+  pkg.TestMedium (fail): estimated 3s doesn't fit in retry time budget
+Commands:
+  go test -v pkg "--test.run=^((TestFast)|(TestFaster))$"
+Total retries: 2 planned, unlimited
+Retry time: 2s planned, 0s of 2s left after the round
+`, plan)
+}
@@ -1,5 +1,7 @@
 package retryer
 
+import "sort"
+
 func filter[T any](ss []T, f ...func(T) bool) (result []T) {
 	for _, s := range ss {
 		add := true
@@ -14,3 +16,12 @@ func filter[T any](ss []T, f ...func(T) bool) (result []T) {
 	}
 	return
 }
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,103 @@
+package retryer
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Policies accepting a retried test by its pass votes, the runs of the test
+// in a retry round.
+const (
+	passPolicyConsecutive = "consecutive"
+	passPolicyMajority    = "majority"
+)
+
+// retryVotes returns the number of runs of every test in a retry round: the
+// -pass-threshold passes in a row with the consecutive policy, or enough
+// runs for a majority of them to reach the threshold with the majority
+// policy. It returns 1 when a single pass is enough.
+func (cfg *Config) retryVotes() int {
+	if cfg.passThreshold <= 1 {
+		return 1
+	}
+	if cfg.passPolicy == passPolicyMajority {
+		return 2*cfg.passThreshold - 1
+	}
+	return cfg.passThreshold
+}
+
+// minPasses returns the passes a retry attempt needs to be accepted, or 0 if
+// all of its runs have to pass.
+func (cfg *Config) minPasses() int {
+	if cfg.passPolicy == passPolicyMajority && cfg.passThreshold > 1 {
+		return cfg.passThreshold
+	}
+	return 0
+}
+
+// votesTestArgs returns the --test.count argument appended to retry commands,
+// so that every retried test runs once per vote, or nothing if a single pass
+// is enough.
+func (r *Retryer) votesTestArgs() string {
+	if votes := r.cfg.retryVotes(); votes > 1 {
+		return fmt.Sprintf(` "--test.count=%v"`, votes)
+	}
+	return ""
+}
+
+// retryRepetitions returns how tests of the current round repeat: retry
+// rounds run every test once per vote.
+func (r *Retryer) retryRepetitions() testRepetitions {
+	rep := r.repetitions
+	if votes := r.cfg.retryVotes(); !r.firstRun && votes > 1 {
+		rep.count = votes
+	}
+	return rep
+}
+
+// recordVotes applies the pass policy to a retry attempt and logs its votes.
+func (r *Retryer) recordVotes(id testID, a *attempt) {
+	if r.firstRun || r.cfg.retryVotes() == 1 || !r.isLastRetriedTest(id) {
+		return
+	}
+	a.voted = true
+	a.minPasses = r.cfg.minPasses()
+	r.logger.Debug("Pass votes", "test", id, "votes", a.votesString(), "accepted", !a.isFailed())
+}
+
+// votesString describes the votes of the attempt and whether they were
+// enough, e.g. "pass,fail,pass: accepted".
+func (a attempt) votesString() string {
+	votes := make([]string, 0, len(a.votes))
+	for _, passed := range a.votes {
+		if passed {
+			votes = append(votes, "pass")
+		} else {
+			votes = append(votes, "fail")
+		}
+	}
+	result := "accepted"
+	if a.isFailed() {
+		result = "rejected"
+	}
+	return strings.Join(votes, ",") + ": " + result
+}
+
+// logVotes logs the votes of every retry attempt of tests that were voted
+// on, so that it's clear why a retried test was accepted or rejected.
+func (r *Retryer) logVotes() {
+	if r.cfg.retryVotes() == 1 {
+		return
+	}
+	votes := make(map[testID][]string)
+	for id, rec := range r.testRecords {
+		for _, a := range rec.attempts {
+			if a.voted {
+				votes[id] = append(votes[id], fmt.Sprintf("round %v: %v", a.round, a.votesString()))
+			}
+		}
+	}
+	if len(votes) > 0 {
+		r.logger.Info("Pass votes", "policy", r.cfg.passPolicy, "threshold", r.cfg.passThreshold, "tests", votes)
+	}
+}